@@ -0,0 +1,135 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Root is the mount point of the unified cgroup v2 hierarchy.
+const Root = "/sys/fs/cgroup"
+
+// Path returns the absolute path of a cgroup given its path relative to the hierarchy root.
+func Path(group string) string {
+	return filepath.Join(Root, group)
+}
+
+// Stats is a snapshot of the counters exposed by a cgroup's controllers.
+type Stats struct {
+	CPUUsageUsec  uint64 `json:"cpu_usage_usec"`
+	CPUUserUsec   uint64 `json:"cpu_user_usec"`
+	CPUSystemUsec uint64 `json:"cpu_system_usec"`
+	MemoryCurrent uint64 `json:"memory_current"`
+	MemoryPeak    uint64 `json:"memory_peak"`
+	IOReadBytes   uint64 `json:"io_read_bytes"`
+	IOWriteBytes  uint64 `json:"io_write_bytes"`
+	PidsCurrent   uint64 `json:"pids_current"`
+}
+
+// ReadStats reads cpu.stat, memory.current, memory.peak, io.stat and pids.current from dir.
+// Files belonging to controllers that are not enabled for the group are skipped.
+func ReadStats(dir string) (*Stats, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	st := &Stats{}
+
+	cpu, err := readKeyed(filepath.Join(dir, "cpu.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	st.CPUUsageUsec = cpu["usage_usec"]
+	st.CPUUserUsec = cpu["user_usec"]
+	st.CPUSystemUsec = cpu["system_usec"]
+
+	for file, dst := range map[string]*uint64{
+		"memory.current": &st.MemoryCurrent,
+		"memory.peak":    &st.MemoryPeak,
+		"pids.current":   &st.PidsCurrent,
+	} {
+		if *dst, err = ReadUint(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	st.IOReadBytes, st.IOWriteBytes, err = readIOStat(filepath.Join(dir, "io.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return st, nil
+}
+
+// ReadUint reads a file holding a single integer, such as memory.current.
+// The value "max" is reported as 0.
+func ReadUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// readKeyed parses a flat "key value" file such as cpu.stat.
+func readKeyed(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = n
+		}
+	}
+	return values, scanner.Err()
+}
+
+// readIOStat sums rbytes and wbytes over all devices listed in io.stat.
+func readIOStat(path string) (read, write uint64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Each line looks like "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, _ := strconv.ParseUint(value, 10, 64)
+			switch key {
+			case "rbytes":
+				read += n
+			case "wbytes":
+				write += n
+			}
+		}
+	}
+	return read, write, scanner.Err()
+}
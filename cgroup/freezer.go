@@ -0,0 +1,69 @@
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// freezeTimeout bounds how long Freeze and Thaw wait for the kernel to settle.
+const freezeTimeout = 10 * time.Second
+
+// Freeze stops every process in the cgroup at dir and waits until the kernel reports it frozen.
+func Freeze(dir string) error {
+	return setFrozen(dir, true)
+}
+
+// Thaw resumes the processes of a cgroup frozen with Freeze.
+func Thaw(dir string) error {
+	return setFrozen(dir, false)
+}
+
+// Frozen reports whether the cgroup at dir is currently frozen.
+func Frozen(dir string) (bool, error) {
+	f, err := os.Open(filepath.Join(dir, "cgroup.events"))
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		if key == "frozen" {
+			return value == "1", nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("%s: no frozen state in cgroup.events (is the freezer supported?)", dir)
+}
+
+func setFrozen(dir string, frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.freeze"), []byte(value), 0644); err != nil {
+		return fmt.Errorf("write cgroup.freeze in %s: %w", dir, err)
+	}
+
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		state, err := Frozen(dir)
+		if err != nil {
+			return err
+		}
+		if state == frozen {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s to become frozen=%s", dir, value)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/OminduD/arch-sandbox/pkgcache"
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/OminduD/arch-sandbox/utils"
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd represents the cache command
+// It groups the subcommands that manage the shared pacman package cache.
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the pacman package cache shared by sandboxes",
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show the size of the package cache and how much clean would free",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keep, _ := cmd.Flags().GetInt("keep")
+		if keep < 0 {
+			log.Fatalf("--keep must not be negative")
+		}
+		dir := sandbox.PackageCacheDir(baseDir)
+		pkgs, err := pkgcache.List(dir)
+		if err != nil {
+			log.Fatalf("Failed to read package cache: %v", err)
+		}
+		stale := pkgcache.Stale(pkgs, keep)
+		names := map[string]bool{}
+		for _, p := range pkgs {
+			names[p.Name] = true
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "Directory:\t%s\n", dir)
+		fmt.Fprintf(w, "Packages:\t%d files of %d packages\n", len(pkgs), len(names))
+		fmt.Fprintf(w, "Size:\t%s\n", utils.HumanBytes(uint64(totalSize(pkgs))))
+		fmt.Fprintf(w, "Reclaimable:\t%s in %d files (keeping %d versions)\n", utils.HumanBytes(uint64(totalSize(stale))), len(stale), keep)
+		w.Flush()
+	},
+}
+
+var cacheCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Remove all but the newest versions of each cached package",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keep, _ := cmd.Flags().GetInt("keep")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if keep < 0 {
+			log.Fatalf("--keep must not be negative")
+		}
+		dir := sandbox.PackageCacheDir(baseDir)
+		if !dryRun {
+			lock, err := pkgcache.LockExclusive(dir)
+			if err != nil {
+				log.Fatalf("Failed to clean package cache: %v", err)
+			}
+			defer lock.Unlock()
+		}
+		pkgs, err := pkgcache.List(dir)
+		if err != nil {
+			log.Fatalf("Failed to read package cache: %v", err)
+		}
+		stale := pkgcache.Stale(pkgs, keep)
+		for _, p := range stale {
+			fmt.Println(filepath.Base(p.File))
+		}
+		if dryRun {
+			log.Printf("Would remove %d packages (%s).", len(stale), utils.HumanBytes(uint64(totalSize(stale))))
+			return
+		}
+		if err := pkgcache.Remove(stale); err != nil {
+			log.Fatalf("Failed to clean package cache: %v", err)
+		}
+		log.Printf("Removed %d packages (%s).", len(stale), utils.HumanBytes(uint64(totalSize(stale))))
+	},
+}
+
+// totalSize adds up the size of package files.
+func totalSize(pkgs []pkgcache.Package) int64 {
+	var n int64
+	for _, p := range pkgs {
+		n += p.Size
+	}
+	return n
+}
+
+func init() {
+	cacheStatsCmd.Flags().IntP("keep", "k", 3, "Number of versions of each package clean would keep")
+	cacheCleanCmd.Flags().IntP("keep", "k", 3, "Number of versions of each package to keep")
+	cacheCleanCmd.Flags().Bool("dry-run", false, "Only list the packages that would be removed")
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheCleanCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
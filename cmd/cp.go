@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// cpCmd represents the cp command
+// It copies files and directories between the host and a sandbox.
+var cpCmd = &cobra.Command{
+	Use:   "cp <src> <dst>",
+	Short: "Copy files between the host and a sandbox",
+	Long: `Copy files or directories between the host and a sandbox. One side is given as
+<name>:<path>, the other is a host path or "-" for a tar stream on stdin or stdout:
+
+  arch-sandbox cp ./nginx.conf web:/etc/nginx/nginx.conf
+  arch-sandbox cp builder:/home/dev/pkg/out ./artifacts
+  tar -C config -cf - . | arch-sandbox cp - web:/etc/myapp
+
+If the destination is an existing directory, the source is copied into it. Modes,
+numeric ownership and timestamps are preserved. Running sandboxes are accessed
+through their namespaces; stopped ones by mounting their filesystem and using the
+host's tar.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := sandbox.ParseCopyTarget(baseDir, args[0])
+		if err != nil {
+			log.Fatalf("Invalid source: %v", err)
+		}
+		dst, err := sandbox.ParseCopyTarget(baseDir, args[1])
+		if err != nil {
+			log.Fatalf("Invalid destination: %v", err)
+		}
+		if err := sandbox.Copy(src, dst); err != nil {
+			log.Fatalf("Copy failed: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cpCmd)
+}
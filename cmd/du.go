@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/OminduD/arch-sandbox/utils"
+	"github.com/spf13/cobra"
+)
+
+// duCmd represents the du command
+// It breaks down the disk space used in the base directory.
+var duCmd = &cobra.Command{
+	Use:   "du",
+	Short: "Show disk usage of sandboxes, snapshots, base images and caches",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		names, err := sandbox.Dirs(baseDir)
+		if err != nil {
+			log.Fatalf("Failed to list sandboxes: %v", err)
+		}
+		var total int64
+		// Root filesystems extracted from each tarball, keyed by tarball file name.
+		roots := map[string]int64{}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SANDBOX\tSTATE\tBASE\tCHANGES\tSNAPSHOTS\tTOTAL")
+		for _, name := range names {
+			sb, err := sandbox.NewSandboxWithBaseDir(name, true, baseDir)
+			if err != nil {
+				continue
+			}
+			u, err := sb.DiskUsage()
+			if err != nil {
+				log.Printf("Failed to measure sandbox '%s': %v", name, err)
+				continue
+			}
+			state := sandboxState(sb)
+			tarball := "unknown"
+			if md, err := sb.LoadMetadata(); err == nil {
+				tarball = filepath.Base(md.TarballURL)
+			} else {
+				state += " (no metadata)"
+			}
+			roots[tarball] += u.Root
+			total += u.Total()
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, state, human(u.Root), human(u.Upper), human(u.Snapshots), human(u.Total()))
+		}
+		w.Flush()
+
+		tarballs, err := sandbox.Tarballs(baseDir)
+		if err != nil {
+			log.Fatalf("Failed to list tarballs: %v", err)
+		}
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "BASE IMAGE\tTARBALL\tEXTRACTED\tSANDBOXES")
+		images := map[string]bool{}
+		for _, t := range tarballs {
+			name := filepath.Base(t.Path)
+			images[name] = true
+			total += t.Size
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", name, human(t.Size), human(roots[name]), len(t.Sandboxes))
+		}
+		// Sandboxes whose tarball is no longer cached still have their extracted root.
+		var uncached []string
+		for name := range roots {
+			if !images[name] && roots[name] > 0 {
+				uncached = append(uncached, name)
+			}
+		}
+		sort.Strings(uncached)
+		for _, name := range uncached {
+			fmt.Fprintf(w, "%s\t-\t%s\t\n", name, human(roots[name]))
+		}
+		w.Flush()
+
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CACHE\tPATH\tSIZE")
+		for _, c := range []struct{ name, path string }{
+			{"packages", sandbox.PackageCacheDir(baseDir)},
+			{"volumes", sandbox.VolumeStore(baseDir).Dir},
+		} {
+			size, _ := utils.DirSize(c.path)
+			total += size
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c.name, c.path, human(size))
+		}
+		w.Flush()
+		fmt.Printf("\nTotal: %s\n", human(total))
+	},
+}
+
+// human formats a byte count for the du and prune tables.
+func human(n int64) string {
+	return utils.HumanBytes(uint64(n))
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+}
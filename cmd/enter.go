@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/spf13/cobra"
+)
+
+// enterCmd represents the hidden enter-filtered command
+// exec and cp run it inside a sandbox to apply its syscall filters to their command.
+var enterCmd = &cobra.Command{
+	Use:                isolation.EnterHelper + " -- command...",
+	Hidden:             true,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		log.Fatalf("Failed to run command: %v", isolation.ExecFiltered(args))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(enterCmd)
+}
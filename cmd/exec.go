@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// execCmd represents the exec command
+// It runs a command, or a login shell, inside a running sandbox.
+var execCmd = &cobra.Command{
+	Use:   "exec <name> [-- command...]",
+	Short: "Run a command in a running sandbox",
+	Long: `Exec runs a command inside a running sandbox, or opens a login shell if no command
+is given. It runs as the sandbox's matched user if it was created with --match-user,
+and as root otherwise; --user overrides this.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		m, err := sb.Machine()
+		if err != nil {
+			log.Fatalf("Cannot exec in sandbox '%s': %v", sb.Name, err)
+		}
+
+		// The saved configuration provides the default user and the environment.
+		var config sandbox.SandboxConfig
+		if md, err := sb.LoadMetadata(); err == nil {
+			config = md.Config
+		}
+		user, _ := cmd.Flags().GetString("user")
+		if user == "" {
+			if config.MatchUser != nil {
+				user = config.MatchUser.Name
+			}
+		} else if err := isolation.ValidateUser(user); err != nil {
+			log.Fatalf("Invalid user: %v", err)
+		}
+		extra, _ := cmd.Flags().GetStringArray("env")
+		config.Env = append(config.Env, extra...)
+		if err := config.Validate(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		env, err := config.Environment()
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		workdir, _ := cmd.Flags().GetString("workdir")
+
+		command := args[1:]
+		if len(command) > 0 && command[0] == "--" {
+			command = command[1:]
+		}
+		err = isolation.Exec(m, user, workdir, env, command)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			os.Exit(exitErr.ExitCode())
+		}
+		if err != nil {
+			log.Fatalf("Exec failed: %v", err)
+		}
+	},
+}
+
+func init() {
+	// Everything after the sandbox name belongs to the command.
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().StringP("user", "u", "", "User to run as: a name or UID[:GID] (default: the matched user, or root)")
+	execCmd.Flags().StringP("workdir", "w", "", "Working directory inside the sandbox")
+	execCmd.Flags().StringArrayP("env", "e", []string{}, "Set an environment variable (KEY=VALUE) in addition to the sandbox's")
+	rootCmd.AddCommand(execCmd)
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// fsckCmd represents the fsck command
+// It checks a stopped sandbox's filesystem after a crash and optionally repairs it.
+var fsckCmd = &cobra.Command{
+	Use:   "fsck <name>",
+	Short: "Check a stopped sandbox's filesystem and repair it",
+	Long: `Check the filesystem of a stopped sandbox: that its base root was completely
+extracted and matches the bootstrap tarball, that nothing is left mounted, and that
+the overlay's upper and work dirs are usable. With --repair, the base root is
+extracted again and the work dir is reset as needed. The upper dir, which holds the
+sandbox's changes, is never modified.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		repair, _ := cmd.Flags().GetBool("repair")
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		// Run sessions on top of the sandbox mount its layers, which a check would report
+		// as left over, and repairs rewrite them.
+		unlock, err := sb.Lock()
+		if err != nil {
+			log.Fatalf("Failed to check sandbox: %v", err)
+		}
+		defer unlock()
+		log.Printf("Checking sandbox '%s'...", sb.Name)
+		problems, err := sb.Check()
+		if err != nil {
+			log.Fatalf("Failed to check sandbox: %v", err)
+		}
+		if len(problems) == 0 {
+			log.Printf("Sandbox '%s' is consistent.", sb.Name)
+			return
+		}
+
+		remaining := 0
+		for _, p := range problems {
+			log.Printf("Problem: %s", p.Description)
+			switch {
+			case p.Repair == "":
+				log.Printf("  cannot be repaired automatically")
+				remaining++
+			case !repair:
+				log.Printf("  repair: %s", p.Repair)
+				remaining++
+			default:
+				log.Printf("  repairing: %s", p.Repair)
+				if err := p.Fix(); err != nil {
+					log.Printf("  repair failed: %v", err)
+					remaining++
+				}
+			}
+		}
+		if remaining == 0 {
+			log.Printf("Sandbox '%s' repaired.", sb.Name)
+			return
+		}
+		if !repair {
+			log.Fatalf("Found %d problems in sandbox '%s'; run with --repair to fix them", remaining, sb.Name)
+		}
+		log.Fatalf("%d problems in sandbox '%s' remain", remaining, sb.Name)
+	},
+}
+
+func init() {
+	fsckCmd.Flags().Bool("repair", false, "Repair the problems found")
+	rootCmd.AddCommand(fsckCmd)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/OminduD/arch-sandbox/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// inspectView is the document printed by the inspect command.
+type inspectView struct {
+	Metadata *sandbox.Metadata `yaml:"metadata"`
+	Paths    map[string]string `yaml:"paths"`
+	Policy   isolation.Profile `yaml:"security_policy"`
+	Disk     *diskView         `yaml:"disk,omitempty"`
+}
+
+// diskView shows the usage of a sandbox with a disk limit.
+type diskView struct {
+	Image string `yaml:"image"`
+	Used  string `yaml:"used"`
+	Limit string `yaml:"limit"`
+}
+
+// inspectCmd represents the inspect command
+// It prints a sandbox's metadata and the effective security policy as YAML.
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <name>",
+	Short: "Show a sandbox's configuration and effective security policy",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		md, err := sb.LoadMetadata()
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		profile, err := isolation.LookupProfile(md.Config.Profile)
+		if err != nil {
+			log.Fatalf("Failed to resolve security profile: %v", err)
+		}
+
+		view := inspectView{
+			Metadata: md,
+			Paths: map[string]string{
+				"base":    sb.BaseDir,
+				"root":    sb.RootDir,
+				"upper":   sb.UpperDir,
+				"work":    sb.WorkDir,
+				"overlay": sb.OverlayDir,
+			},
+			Policy: profile,
+		}
+		if sb.DiskImage != "" {
+			used, limit, err := sb.DiskQuota()
+			if err != nil {
+				log.Fatalf("Failed to read disk usage: %v", err)
+			}
+			view.Paths["disk"] = sb.DiskDir
+			view.Disk = &diskView{Image: sb.DiskImage, Used: utils.HumanBytes(used), Limit: utils.HumanBytes(limit)}
+		}
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(&view); err != nil {
+			log.Fatalf("Failed to print sandbox: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(inspectCmd)
+}
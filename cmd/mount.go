@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// mountCmd represents the mount command
+// It mounts the filesystem of a stopped sandbox on the host without launching it.
+var mountCmd = &cobra.Command{
+	Use:   "mount <name> [path]",
+	Short: "Mount a stopped sandbox's filesystem on the host",
+	Long: `Mount the filesystem of a stopped persistent sandbox on the host for inspection or
+editing, at path or at <base-dir>/<name>/mnt. Changes made through a read-write mount
+go to the sandbox's upper dir as if made inside it. The sandbox cannot be started or
+removed until it is unmounted with umount.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		hm := sandbox.HostMount{Path: sb.DefaultMountPath()}
+		if len(args) > 1 {
+			hm.Path = args[1]
+		}
+		hm.ReadOnly, _ = cmd.Flags().GetBool("read-only")
+		hm.Snapshot, _ = cmd.Flags().GetString("snapshot")
+		mounted, err := sb.MountOnHost(hm)
+		if err != nil {
+			log.Fatalf("Failed to mount sandbox: %v", err)
+		}
+		mode := "read-write"
+		if mounted.ReadOnly {
+			mode = "read-only"
+		}
+		log.Printf("Sandbox '%s' mounted %s at %s.", sb.Name, mode, mounted.Path)
+	},
+}
+
+// umountCmd represents the umount command
+// It undoes mount.
+var umountCmd = &cobra.Command{
+	Use:     "umount <name>",
+	Aliases: []string{"unmount"},
+	Short:   "Unmount a sandbox mounted on the host with mount",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		if err := sb.UnmountFromHost(); err != nil {
+			log.Fatalf("Failed to unmount sandbox: %v", err)
+		}
+		log.Printf("Sandbox '%s' unmounted.", sb.Name)
+	},
+}
+
+func init() {
+	mountCmd.Flags().Bool("read-only", false, "Mount the filesystem read-only")
+	mountCmd.Flags().String("snapshot", "", "Mount the sandbox as it was at this snapshot (always read-only)")
+	rootCmd.AddCommand(mountCmd)
+	rootCmd.AddCommand(umountCmd)
+}
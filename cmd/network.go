@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// networkCmd represents the network command
+// It groups the subcommands that manage named sandbox networks.
+var networkCmd = &cobra.Command{
+	Use:   "network",
+	Short: "Manage named networks shared between sandboxes",
+}
+
+var networkCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a named network",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		subnet, _ := cmd.Flags().GetString("subnet")
+		n, err := sandbox.NetworkStore(baseDir).Create(args[0], subnet)
+		if err != nil {
+			log.Fatalf("Failed to create network: %v", err)
+		}
+		log.Printf("Network '%s' created with subnet %s (bridge %s).", n.Name, n.Subnet, n.Bridge)
+	},
+}
+
+var networkListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List networks and their members",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		networks, err := sandbox.NetworkStore(baseDir).List()
+		if err != nil {
+			log.Fatalf("Failed to list networks: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tBRIDGE\tSUBNET\tMEMBERS")
+		for _, n := range networks {
+			members := make([]string, 0, len(n.Leases))
+			for name, addr := range n.Leases {
+				members = append(members, name+"="+addr)
+			}
+			sort.Strings(members)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n.Name, n.Bridge, n.Subnet, strings.Join(members, ", "))
+		}
+		w.Flush()
+	},
+}
+
+var networkRemoveCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a network that has no members",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := sandbox.NetworkStore(baseDir).Remove(args[0]); err != nil {
+			log.Fatalf("Failed to remove network: %v", err)
+		}
+		log.Printf("Network '%s' removed.", args[0])
+	},
+}
+
+func init() {
+	networkCreateCmd.Flags().String("subnet", "", "IPv4 subnet of the network (default: next free /24 in 10.89.0.0/16)")
+	networkCmd.AddCommand(networkCreateCmd)
+	networkCmd.AddCommand(networkListCmd)
+	networkCmd.AddCommand(networkRemoveCmd)
+	rootCmd.AddCommand(networkCmd)
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// pruneCmd represents the prune command
+// It removes what sandboxes leave behind: crashed ephemeral sandboxes, unused tarballs
+// and, if asked for, old snapshots.
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove leftover ephemeral sandboxes, unused tarballs and old snapshots",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		var policy sandbox.PrunePolicy
+		if age, _ := cmd.Flags().GetString("snapshots-older-than"); age != "" {
+			d, err := parseAge(age)
+			if err != nil {
+				log.Fatalf("Invalid --snapshots-older-than: %v", err)
+			}
+			policy.SnapshotAge = d
+		}
+		items, err := sandbox.Prunable(baseDir, policy)
+		if err != nil {
+			log.Fatalf("Failed to find what to prune: %v", err)
+		}
+		if len(items) == 0 {
+			log.Println("Nothing to prune.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tNAME\tSIZE")
+		for _, item := range items {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", item.Kind, item.Name, human(item.Size))
+		}
+		w.Flush()
+
+		var freed int64
+		failed := false
+		for _, item := range items {
+			if dryRun {
+				freed += item.Size
+				continue
+			}
+			if err := item.Remove(); err != nil {
+				log.Printf("Failed to remove %s '%s': %v", item.Kind, item.Name, err)
+				failed = true
+				continue
+			}
+			freed += item.Size
+		}
+		if dryRun {
+			log.Printf("Would free %s.", human(freed))
+			return
+		}
+		log.Printf("Freed %s.", human(freed))
+		if failed {
+			log.Fatalf("Some items could not be removed")
+		}
+	},
+}
+
+// parseAge parses a duration that may also be given in days, e.g. 30d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a positive number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("%q must be positive", s)
+	}
+	return d, err
+}
+
+func init() {
+	pruneCmd.Flags().Bool("dry-run", false, "Only list what would be removed")
+	pruneCmd.Flags().String("snapshots-older-than", "", "Also remove snapshots older than this (e.g., 30d or 72h)")
+	rootCmd.AddCommand(pruneCmd)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// rmCmd represents the rm command
+// It deletes stopped sandboxes. Named volumes they used are kept.
+var rmCmd = &cobra.Command{
+	Use:     "rm <name>...",
+	Aliases: []string{"remove"},
+	Short:   "Remove stopped sandboxes",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		failed := false
+		for _, name := range args {
+			sb, err := sandbox.NewSandboxWithBaseDir(name, true, baseDir)
+			if err != nil {
+				log.Printf("Failed to load sandbox '%s': %v", name, err)
+				failed = true
+				continue
+			}
+			if err := sb.Remove(force); err != nil {
+				log.Printf("Failed to remove sandbox '%s': %v", name, err)
+				failed = true
+				continue
+			}
+			log.Printf("Sandbox '%s' removed.", name)
+		}
+		if failed {
+			log.Fatalf("Some sandboxes could not be removed")
+		}
+	},
+}
+
+func init() {
+	rmCmd.Flags().BoolP("force", "f", false, "Lazily detach a sandbox filesystem that is still in use")
+	rootCmd.AddCommand(rmCmd)
+}
@@ -2,15 +2,24 @@ package cmd
 
 //Import packages
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"syscall"
 
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/network"
 	"github.com/OminduD/arch-sandbox/sandbox"
 	"github.com/OminduD/arch-sandbox/snapshot"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
@@ -35,35 +44,62 @@ var newCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		name := args[0]
-		persist, _ := cmd.Flags().GetBool("persist")
-		networkMode, _ := cmd.Flags().GetString("network")
-		dns, _ := cmd.Flags().GetStringSlice("dns")
-		ports, _ := cmd.Flags().GetStringSlice("port")
-		cpuShares, _ := cmd.Flags().GetString("cpu-shares")
-		memoryLimit, _ := cmd.Flags().GetString("memory-limit")
-
-		sb, err := sandbox.NewSandboxWithBaseDir(name, persist, baseDir)
+		config, err := configFromFlags(cmd, name)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		if err := config.Validate(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+
+		sb, err := sandbox.NewSandboxWithBaseDir(name, config.Persist, baseDir)
 		if err != nil {
 			log.Fatalf("Failed to create sandbox: %v", err)
 		}
+		if config.Tarball != "" {
+			sb.TarballURL = config.Tarball
+		}
 
-		// The config is for features like pre-installing packages from a file, not yet implemented via CLI flags.
-		config := sandbox.SandboxConfig{}
 		if err := sb.Setup(config); err != nil {
 			log.Fatalf("Sandbox setup failed: %v", err)
 		}
-
-		if err := sb.Launch(networkMode, dns, ports, cpuShares, memoryLimit); err != nil {
-			log.Fatalf("Sandbox launch failed: %v", err)
+		if err := sb.SaveMetadata(config); err != nil {
+			log.Printf("Warning: failed to save sandbox metadata: %v", err)
 		}
 
-		// Cleanup is handled after the sandbox session ends.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		launchErr := sb.Launch(ctx, config)
+
+		// Cleanup runs after the sandbox session ends, however it ended.
 		if err := sb.Cleanup(); err != nil {
-			log.Fatalf("Sandbox cleanup failed: %v", err)
+			log.Printf("Sandbox cleanup failed: %v", err)
 		}
+		exitOnLaunchError(launchErr)
 	},
 }
 
+// exitTimeout is the exit code used when a session hits --timeout or --idle-timeout,
+// matching timeout(1).
+const exitTimeout = 124
+
+// exitOnLaunchError exits with a status describing why a sandbox session failed.
+// A command that failed inside the sandbox passes its exit status through.
+func exitOnLaunchError(err error) {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return
+	case errors.Is(err, isolation.ErrTimeout), errors.Is(err, isolation.ErrIdleTimeout):
+		log.Printf("Sandbox stopped: %v", err)
+		os.Exit(exitTimeout)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		os.Exit(exitErr.ExitCode())
+	default:
+		log.Fatalf("Sandbox launch failed: %v", err)
+	}
+}
+
 // snapshotCmd represents the snapshot command
 
 var snapshotCmd = &cobra.Command{
@@ -74,28 +110,92 @@ var snapshotCmd = &cobra.Command{
 		sandboxName := args[0]
 		action := args[1]
 		sandboxPath := filepath.Join(baseDir, sandboxName)
+		sb, err := sandbox.NewSandboxWithBaseDir(sandboxName, true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+
+		// The upper dir of a stopped sandbox with a disk limit is only reachable with its image mounted.
+		// A sandbox mounted on the host may already have it mounted.
+		unmountDisk := func() {}
+		if state, err := sb.State(); err == nil && (state == sandbox.StateStopped || state == sandbox.StateMounted) && !sb.DiskMounted() {
+			if err := sb.MountDisk(); err != nil {
+				log.Fatalf("Failed to mount sandbox disk image: %v", err)
+			}
+			unmountDisk = func() { sb.UnmountDisk() }
+		}
+		defer unmountDisk()
+		// log.Fatalf skips deferred calls.
+		fatalf := func(format string, v ...interface{}) {
+			unmountDisk()
+			log.Fatalf(format, v...)
+		}
 
 		switch action {
 		case "save":
 			if len(args) < 3 {
-				log.Fatalf("Missing snapshot-id for save action")
+				fatalf("Missing snapshot-id for save action")
 			}
 			snapshotID := args[2]
-			if err := snapshot.SaveSnapshot(sandboxPath, snapshotID); err != nil {
-				log.Fatalf("Failed to save snapshot: %v", err)
+			var volumes []string
+			if includeVolumes, _ := cmd.Flags().GetBool("include-volumes"); includeVolumes {
+				md, err := sb.LoadMetadata()
+				if err != nil {
+					fatalf("Failed to load sandbox: %v", err)
+				}
+				volumes = md.Config.Volumes()
+			}
+			// A running sandbox is frozen while its upper dir is archived so the snapshot is consistent.
+			err := sb.WhilePaused(func() error {
+				if err := snapshot.SaveSnapshot(sandboxPath, sb.WritableDir(), snapshotID); err != nil {
+					return err
+				}
+				store := sandbox.VolumeStore(baseDir)
+				for _, name := range volumes {
+					if err := snapshot.SaveVolume(sandboxPath, snapshotID, name, store.DataPath(name)); err != nil {
+						return fmt.Errorf("volume %s: %w", name, err)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				fatalf("Failed to save snapshot: %v", err)
 			}
 			log.Printf("Snapshot '%s' saved for sandbox '%s'.\n", snapshotID, sandboxName)
 		case "restore":
 			if len(args) < 3 {
-				log.Fatalf("Missing snapshot-id for restore action")
+				fatalf("Missing snapshot-id for restore action")
 			}
 			snapshotID := args[2]
-			if err := snapshot.RestoreSnapshot(sandboxPath, snapshotID); err != nil {
-				log.Fatalf("Failed to restore snapshot: %v", err)
+			unlock, err := sb.Lock()
+			if err != nil {
+				fatalf("Cannot restore snapshot: %v", err)
+			}
+			defer unlock()
+			if state, err := sb.State(); err != nil || state != sandbox.StateStopped {
+				fatalf("Cannot restore snapshot: sandbox '%s' must be stopped (state: %s)", sandboxName, sandboxState(sb))
+			}
+			if err := snapshot.RestoreSnapshot(sandboxPath, sb.WritableDir(), snapshotID); err != nil {
+				fatalf("Failed to restore snapshot: %v", err)
+			}
+			// Volumes are only part of the snapshot if it was saved with --include-volumes.
+			volumes, err := snapshot.SnapshotVolumes(sandboxPath, snapshotID)
+			if err != nil {
+				fatalf("Failed to restore snapshot: %v", err)
+			}
+			for _, name := range volumes {
+				v, err := sandbox.VolumeStore(baseDir).Ensure(name)
+				if err != nil {
+					fatalf("Failed to restore volume '%s': %v", name, err)
+				}
+				if err := snapshot.RestoreVolume(sandboxPath, snapshotID, name, v.Path); err != nil {
+					fatalf("Failed to restore volume '%s': %v", name, err)
+				}
+				log.Printf("Volume '%s' restored.", name)
 			}
 			log.Printf("Snapshot '%s' restored for sandbox '%s'.\n", snapshotID, sandboxName)
 		default:
-			log.Fatalf("Unknown action: %s. Use 'save' or 'restore'.", action)
+			fatalf("Unknown action: %s. Use 'save' or 'restore'.", action)
 		}
 	},
 }
@@ -115,17 +215,34 @@ var installCmd = &cobra.Command{
 			log.Fatalf("Failed to load sandbox: %v", err)
 		}
 
+		var config sandbox.SandboxConfig
+		if md, err := sb.LoadMetadata(); err == nil {
+			config = md.Config
+		}
+		matched := config.MatchUser
+		releaseCache, err := sb.MountPackageCache(config)
+		if err != nil {
+			log.Fatalf("Failed to mount package cache: %v", err)
+		}
+		defer releaseCache()
+
 		// Ensure AUR helper is installed
-		if err := sb.InstallAURHelper("yay"); err != nil {
+		if err := sb.InstallAURHelper("yay", matched); err != nil {
 			log.Printf("Could not install AUR helper, proceeding with pacman: %v", err)
 		}
 
 		log.Printf("Installing package '%s' in sandbox '%s'...", packageName, sandboxName)
 		// Use arch-chroot to run commands inside the sandbox's filesystem
-		cmdExec := exec.Command("arch-chroot", sb.OverlayDir, "yay", "-S", "--noconfirm", packageName)
+		chrootArgs := []string{sb.OverlayDir, "yay", "-S", "--noconfirm", packageName}
+		if matched != nil && matched.Sudo {
+			// yay refuses to run as root and escalates with sudo itself.
+			chrootArgs = append([]string{sb.OverlayDir, "runuser", "-u", matched.Name, "--"}, chrootArgs[1:]...)
+		}
+		cmdExec := exec.Command("arch-chroot", chrootArgs...)
 		cmdExec.Stdout = os.Stdout
 		cmdExec.Stderr = os.Stderr
 		if err := cmdExec.Run(); err != nil {
+			releaseCache()
 			log.Fatalf("Failed to install package: %v", err)
 		}
 		log.Printf("Successfully installed '%s' in '%s'.", packageName, sandboxName)
@@ -137,12 +254,46 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&baseDir, "base-dir", getDefaultBaseDir(), "Base directory for sandboxes")
 
 	// `new` command flags
+	newCmd.Flags().StringP("config", "c", "", "Path to a YAML sandbox configuration file")
 	newCmd.Flags().BoolP("persist", "p", false, "Persist sandbox after exit")
-	newCmd.Flags().String("network", "host", "Network mode: host, private, none")
+	newCmd.Flags().String("network", "host", "Network mode: host, private, none or a network name")
+	newCmd.Flags().String("subnet", "", "Subnet of the private network when it is first created (default "+network.DefaultSubnet+")")
 	newCmd.Flags().StringSlice("dns", []string{}, "Custom DNS servers for private network mode")
 	newCmd.Flags().StringSlice("port", []string{}, "Port mappings (e.g., host:container)")
-	newCmd.Flags().String("cpu-shares", "", "CPU shares (weight)")
+	newCmd.Flags().String("egress", "", "Outgoing traffic policy: none, allow, mirrors-only (default: unrestricted)")
+	newCmd.Flags().StringSlice("egress-allow", []string{}, "Destinations allowed by --egress allow (host, IP or CIDR, optionally :port)")
+	newCmd.Flags().String("tmpfs", "", "Keep all changes in memory on a tmpfs of this size (e.g., --tmpfs=2G)")
+	newCmd.Flags().Lookup("tmpfs").NoOptDefVal = sandbox.DefaultTmpfsSize
+	newCmd.Flags().String("disk-limit", "", "Maximum size of the sandbox's changes (e.g., 20G)")
+	newCmd.Flags().Bool("no-shared-cache", false, "Keep downloaded packages in the sandbox instead of the shared package cache")
+	newCmd.Flags().StringSliceP("volume", "v", []string{}, "Mount a host path or named volume (src:dst[:ro|rw])")
+	newCmd.Flags().StringSlice("tmpfs-mount", []string{}, "Mount a tmpfs inside the sandbox (dst[:options])")
+	newCmd.Flags().String("cpu-shares", "", "CPU shares (weight, 1-10000)")
+	newCmd.Flags().String("cpus", "", "CPU quota in CPUs (e.g., 1.5)")
+	newCmd.Flags().String("cpuset", "", "CPUs the sandbox may run on (e.g., 0-3,6)")
+	newCmd.Flags().String("pids-max", "", "Maximum number of processes")
 	newCmd.Flags().String("memory-limit", "", "Memory limit (e.g., 1G)")
+	newCmd.Flags().String("memory-high", "", "Memory usage above which the sandbox is throttled (e.g., 768M)")
+	newCmd.Flags().String("memory-swap-max", "", "Swap limit (e.g., 512M)")
+	newCmd.Flags().String("io-weight", "", "IO weight (1-10000)")
+	newCmd.Flags().StringSlice("device-read-bps", []string{}, "Read bandwidth limits (e.g., /dev/sda:10M)")
+	newCmd.Flags().StringSlice("device-write-bps", []string{}, "Write bandwidth limits (e.g., /dev/sda:10M)")
+	newCmd.Flags().String("timeout", "", "Stop the sandbox after this long (e.g., 30m)")
+	newCmd.Flags().String("idle-timeout", "", "Stop the sandbox after this long without output or CPU activity")
+	newCmd.Flags().StringArrayP("env", "e", []string{}, "Set an environment variable (KEY=VALUE)")
+	newCmd.Flags().StringSlice("env-file", []string{}, "Read environment variables from a KEY=VALUE file")
+	newCmd.Flags().String("hostname", "", "Hostname inside the sandbox (default: the sandbox name)")
+	newCmd.Flags().String("timezone", "", "Timezone: copy or bind the host's, off, or a zone like Europe/Berlin")
+	newCmd.Flags().String("locale", "", "Locale to generate and use, e.g. en_US.UTF-8")
+	newCmd.Flags().StringArray("secret", []string{}, "Mount a host file read-only at /run/secrets/<id> (id=name,src=file)")
+	newCmd.Flags().StringSlice("gui", []string{}, "Forward host GUI sockets: wayland, x11, audio, dbus or all")
+	newCmd.Flags().Bool("match-user", false, "Create a user mirroring the invoking host user and log in as them")
+	newCmd.Flags().Bool("sudo", false, "Give the matched user passwordless sudo")
+	newCmd.Flags().Bool("bind-home", false, "Mount the host home directory as the matched user's home")
+	newCmd.Flags().StringSlice("dotfiles", []string{}, "Files from the host home to mount read-only for the matched user (e.g., .gitconfig)")
+	newCmd.Flags().String("profile", isolation.DefaultProfile, "Security profile: "+strings.Join(isolation.ProfileNames(), ", "))
+
+	snapshotCmd.Flags().Bool("include-volumes", false, "Also archive the named volumes the sandbox mounts (save)")
 
 	// Add subcommands to root
 	rootCmd.AddCommand(newCmd)
@@ -157,6 +308,159 @@ func Execute() {
 	}
 }
 
+// configFromFlags loads the --config file, if given, and applies the flags on top of it.
+// A flag overrides the file only when it was set explicitly or the file leaves the field empty.
+func configFromFlags(cmd *cobra.Command, name string) (sandbox.SandboxConfig, error) {
+	flags := cmd.Flags()
+	var config sandbox.SandboxConfig
+	if path, _ := flags.GetString("config"); path != "" {
+		var err error
+		if config, err = sandbox.LoadConfig(path); err != nil {
+			return config, err
+		}
+	}
+	config.Name = name
+	return config, applyFlags(flags, &config)
+}
+
+// applyFlags copies the launch flags defined on a command into config.
+// Mount flags add to the configured mounts instead of replacing them.
+func applyFlags(flags *pflag.FlagSet, config *sandbox.SandboxConfig) error {
+	if flags.Changed("persist") {
+		config.Persist, _ = flags.GetBool("persist")
+	}
+	stringFlag(flags, "network", &config.Network.Mode)
+	stringFlag(flags, "subnet", &config.Network.Subnet)
+	sliceFlag(flags, "dns", &config.Network.DNS)
+	sliceFlag(flags, "port", &config.Network.Ports)
+	stringFlag(flags, "egress", &config.Network.Egress.Mode)
+	sliceFlag(flags, "egress-allow", &config.Network.Egress.Allow)
+	if len(config.Network.Egress.Allow) > 0 && config.Network.Egress.Mode == "" {
+		config.Network.Egress.Mode = network.EgressAllow
+	}
+	stringFlag(flags, "cpu-shares", &config.Resources.CPUWeight)
+	stringFlag(flags, "cpus", &config.Resources.CPUs)
+	stringFlag(flags, "cpuset", &config.Resources.CPUSet)
+	stringFlag(flags, "pids-max", &config.Resources.PidsMax)
+	stringFlag(flags, "memory-limit", &config.Resources.MemoryMax)
+	stringFlag(flags, "memory-high", &config.Resources.MemoryHigh)
+	stringFlag(flags, "memory-swap-max", &config.Resources.MemorySwapMax)
+	stringFlag(flags, "io-weight", &config.Resources.IOWeight)
+	sliceFlag(flags, "device-read-bps", &config.Resources.IOReadBandwidth)
+	sliceFlag(flags, "device-write-bps", &config.Resources.IOWriteBandwidth)
+	stringFlag(flags, "profile", &config.Profile)
+	stringFlag(flags, "timeout", &config.Timeout)
+	stringFlag(flags, "idle-timeout", &config.IdleTimeout)
+	sliceFlag(flags, "gui", &config.GUI)
+	stringFlag(flags, "hostname", &config.Hostname)
+	stringFlag(flags, "timezone", &config.Timezone)
+	stringFlag(flags, "locale", &config.Locale)
+	stringFlag(flags, "tmpfs", &config.Tmpfs)
+	stringFlag(flags, "disk-limit", &config.DiskLimit)
+	if flags.Changed("no-shared-cache") {
+		config.NoSharedCache, _ = flags.GetBool("no-shared-cache")
+	}
+	if flags.Lookup("env") != nil {
+		env, _ := flags.GetStringArray("env")
+		config.Env = append(config.Env, env...)
+	}
+	if flags.Lookup("secret") != nil {
+		secrets, _ := flags.GetStringArray("secret")
+		for _, spec := range secrets {
+			secret, err := sandbox.ParseSecret(spec)
+			if err != nil {
+				return err
+			}
+			config.Secrets = append(config.Secrets, secret)
+		}
+	}
+	if flags.Lookup("env-file") != nil {
+		files, _ := flags.GetStringSlice("env-file")
+		for _, f := range files {
+			abs, err := filepath.Abs(f)
+			if err != nil {
+				return err
+			}
+			config.EnvFiles = append(config.EnvFiles, abs)
+		}
+	}
+
+	if flags.Lookup("volume") != nil {
+		volumes, _ := flags.GetStringSlice("volume")
+		for _, spec := range volumes {
+			m, err := isolation.ParseVolume(spec)
+			if err != nil {
+				return err
+			}
+			config.Mounts = append(config.Mounts, m)
+		}
+	}
+	if flags.Lookup("tmpfs-mount") != nil {
+		tmpfs, _ := flags.GetStringSlice("tmpfs-mount")
+		for _, spec := range tmpfs {
+			m, err := isolation.ParseTmpfs(spec)
+			if err != nil {
+				return err
+			}
+			config.Mounts = append(config.Mounts, m)
+		}
+	}
+	if flags.Lookup("match-user") != nil {
+		return applyUserFlags(flags, config)
+	}
+	return nil
+}
+
+// applyUserFlags sets up the mirrored host user from --match-user and the options refining it.
+func applyUserFlags(flags *pflag.FlagSet, config *sandbox.SandboxConfig) error {
+	match, _ := flags.GetBool("match-user")
+	if !match && config.MatchUser == nil {
+		if flags.Changed("sudo") || flags.Changed("bind-home") || flags.Changed("dotfiles") {
+			return errors.New("--sudo, --bind-home and --dotfiles require --match-user")
+		}
+		return nil
+	}
+	// A configuration file may ask for a matched user without naming it.
+	if config.MatchUser == nil || config.MatchUser.Name == "" {
+		u, err := sandbox.HostUser()
+		if err != nil {
+			return err
+		}
+		if config.MatchUser != nil {
+			u.Sudo, u.BindHome, u.Dotfiles = config.MatchUser.Sudo, config.MatchUser.BindHome, config.MatchUser.Dotfiles
+		}
+		config.MatchUser = u
+	}
+	if flags.Changed("sudo") {
+		config.MatchUser.Sudo, _ = flags.GetBool("sudo")
+	}
+	if flags.Changed("bind-home") {
+		config.MatchUser.BindHome, _ = flags.GetBool("bind-home")
+	}
+	sliceFlag(flags, "dotfiles", &config.MatchUser.Dotfiles)
+	return nil
+}
+
+// stringFlag copies a string flag into dst if it was set or dst is still empty.
+func stringFlag(flags *pflag.FlagSet, name string, dst *string) {
+	if flags.Lookup(name) == nil {
+		return
+	}
+	if flags.Changed(name) || *dst == "" {
+		*dst, _ = flags.GetString(name)
+	}
+}
+
+// sliceFlag copies a string slice flag into dst if it was set or dst is still empty.
+func sliceFlag(flags *pflag.FlagSet, name string, dst *[]string) {
+	if flags.Lookup(name) == nil {
+		return
+	}
+	if flags.Changed(name) || len(*dst) == 0 {
+		*dst, _ = flags.GetStringSlice(name)
+	}
+}
+
 func getDefaultBaseDir() string {
 	usr, err := user.Current()
 	if err != nil {
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// runCmd represents the run command
+// It runs a command against the current directory in a throwaway sandbox.
+var runCmd = &cobra.Command{
+	Use:   "run [flags] [-- command...]",
+	Short: "Run a command on the current directory in an ephemeral sandbox",
+	Long: `Run binds the current directory at /workspace in a fresh, ephemeral sandbox and
+runs the command there (an interactive shell if none is given). When run through
+sudo, the command runs with the invoking user's UID and GID so files written to
+/workspace are owned by them. With --sandbox, the ephemeral sandbox is layered on
+top of an existing stopped sandbox, which is left unchanged.`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cwd, err := os.Getwd()
+		if err != nil {
+			log.Fatalf("Failed to get working directory: %v", err)
+		}
+
+		var base *sandbox.Sandbox
+		var config sandbox.SandboxConfig
+		if name, _ := cmd.Flags().GetString("sandbox"); name != "" {
+			if base, err = sandbox.NewSandboxWithBaseDir(name, true, baseDir); err != nil {
+				log.Fatalf("Failed to load sandbox: %v", err)
+			}
+			md, err := base.LoadMetadata()
+			if err != nil {
+				log.Fatalf("Failed to load sandbox: %v", err)
+			}
+			base.TarballURL = md.TarballURL
+			config = md.Config
+			config.Command, config.Workdir, config.User = nil, "", ""
+		}
+
+		sb, err := sandbox.NewRunSandbox(baseDir, base)
+		if err != nil {
+			log.Fatalf("Failed to create sandbox: %v", err)
+		}
+		// The base's upper dir becomes a lower layer and must not change underneath us.
+		// NewRunSandbox locked it against start, restore and removal; check it is not
+		// already in use.
+		if base != nil {
+			if state, err := base.State(); err != nil || state != sandbox.StateStopped {
+				log.Fatalf("Sandbox '%s' must be stopped to run on top of it (state: %s)", base.Name, sandboxState(base))
+			}
+		}
+		if image, _ := cmd.Flags().GetString("image"); image != "" {
+			if base != nil {
+				log.Fatalf("--image and --sandbox cannot be combined")
+			}
+			sb.TarballURL = image
+		}
+
+		config.Name = sb.Name
+		config.Persist = false
+		config.Tarball = sb.TarballURL
+		if err := applyFlags(cmd.Flags(), &config); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		config.Mounts = append(config.Mounts, sandbox.WorkspaceMount(cwd))
+		config.Workdir = sandbox.WorkspaceDir
+		if config.MatchUser == nil {
+			config.User = sandbox.InvokingUser()
+		}
+		if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+			config.Command = args[dash:]
+		} else {
+			config.Command = args
+		}
+		if err := config.Validate(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+
+		if err := sb.Setup(config); err != nil {
+			sb.Cleanup()
+			log.Fatalf("Sandbox setup failed: %v", err)
+		}
+		if err := sb.SaveMetadata(config); err != nil {
+			log.Printf("Warning: failed to save sandbox metadata: %v", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		launchErr := sb.Launch(ctx, config)
+
+		if err := sb.Cleanup(); err != nil {
+			log.Printf("Sandbox cleanup failed: %v", err)
+		}
+		exitOnLaunchError(launchErr)
+	},
+}
+
+func init() {
+	// Everything after the first argument belongs to the command, e.g. run make -j4.
+	runCmd.Flags().SetInterspersed(false)
+	runCmd.Flags().String("image", "", "Bootstrap tarball URL to build the sandbox from")
+	runCmd.Flags().String("sandbox", "", "Layer the ephemeral sandbox on top of this stopped sandbox")
+	runCmd.Flags().String("network", "host", "Network mode: host, private, none or a network name")
+	runCmd.Flags().String("tmpfs", "", "Keep all changes in memory on a tmpfs of this size (e.g., --tmpfs=2G)")
+	runCmd.Flags().Lookup("tmpfs").NoOptDefVal = sandbox.DefaultTmpfsSize
+	runCmd.Flags().StringSliceP("volume", "v", []string{}, "Mount a host path or named volume (src:dst[:ro|rw])")
+	runCmd.Flags().StringSlice("tmpfs-mount", []string{}, "Mount a tmpfs inside the sandbox (dst[:options])")
+	runCmd.Flags().StringArrayP("env", "e", []string{}, "Set an environment variable (KEY=VALUE)")
+	runCmd.Flags().StringSlice("env-file", []string{}, "Read environment variables from a KEY=VALUE file")
+	runCmd.Flags().StringArray("secret", []string{}, "Mount a host file read-only at /run/secrets/<id> (id=name,src=file)")
+	runCmd.Flags().StringSlice("gui", []string{}, "Forward host GUI sockets: wayland, x11, audio, dbus or all")
+	runCmd.Flags().Bool("no-shared-cache", false, "Keep downloaded packages in the sandbox instead of the shared package cache")
+	runCmd.Flags().String("timeout", "", "Stop the sandbox after this long (e.g., 30m)")
+	runCmd.Flags().String("idle-timeout", "", "Stop the sandbox after this long without output or CPU activity")
+	runCmd.Flags().String("profile", isolation.DefaultProfile, "Security profile: "+strings.Join(isolation.ProfileNames(), ", "))
+	rootCmd.AddCommand(runCmd)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// startCmd represents the start command
+// It launches an existing persistent sandbox again with its saved configuration.
+var startCmd = &cobra.Command{
+	Use:   "start <name>",
+	Short: "Start an existing persistent sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		md, err := sb.LoadMetadata()
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		if !md.Persist {
+			log.Fatalf("Sandbox '%s' is not persistent and cannot be restarted", sb.Name)
+		}
+		unlock, err := sb.Lock()
+		if err != nil {
+			log.Fatalf("Failed to start sandbox: %v", err)
+		}
+		defer unlock()
+		if state, err := sb.State(); err == nil && state == sandbox.StateMounted {
+			log.Fatalf("Sandbox '%s' is mounted on the host; unmount it with 'arch-sandbox umount %s' first", sb.Name, sb.Name)
+		} else if err != nil || state != sandbox.StateStopped {
+			log.Fatalf("Sandbox '%s' is already %s", sb.Name, sandboxState(sb))
+		}
+		sb.TarballURL = md.TarballURL
+
+		// Flags given to start override the saved configuration for this session only.
+		config := md.Config
+		if err := applyFlags(cmd.Flags(), &config); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		if err := config.Validate(); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+
+		if err := sb.Mount(); err != nil {
+			log.Fatalf("Failed to mount sandbox: %v", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		launchErr := sb.Launch(ctx, config)
+
+		if err := sb.Cleanup(); err != nil {
+			log.Printf("Sandbox cleanup failed: %v", err)
+		}
+		exitOnLaunchError(launchErr)
+	},
+}
+
+func init() {
+	startCmd.Flags().String("network", "", "Network mode: host, private, none or a network name")
+	startCmd.Flags().StringSlice("dns", []string{}, "Custom DNS servers for private network mode")
+	startCmd.Flags().StringSlice("port", []string{}, "Port mappings (e.g., host:container)")
+	startCmd.Flags().String("egress", "", "Outgoing traffic policy: none, allow, mirrors-only")
+	startCmd.Flags().StringSlice("egress-allow", []string{}, "Destinations allowed by --egress allow (host, IP or CIDR, optionally :port)")
+	startCmd.Flags().StringSliceP("volume", "v", []string{}, "Mount a host path or named volume for this session (src:dst[:ro|rw])")
+	startCmd.Flags().StringSlice("tmpfs-mount", []string{}, "Mount a tmpfs inside the sandbox for this session (dst[:options])")
+	startCmd.Flags().StringArrayP("env", "e", []string{}, "Set an environment variable (KEY=VALUE) for this session")
+	startCmd.Flags().StringSlice("env-file", []string{}, "Read environment variables from a KEY=VALUE file for this session")
+	startCmd.Flags().String("hostname", "", "Hostname inside the sandbox for this session")
+	startCmd.Flags().String("timezone", "", "Timezone: copy or bind the host's, off, or a zone like Europe/Berlin")
+	startCmd.Flags().String("locale", "", "Locale to generate and use, e.g. en_US.UTF-8")
+	startCmd.Flags().StringArray("secret", []string{}, "Mount a host file read-only at /run/secrets/<id> (id=name,src=file)")
+	startCmd.Flags().StringSlice("gui", []string{}, "Forward host GUI sockets: wayland, x11, audio, dbus or all")
+	startCmd.Flags().Bool("no-shared-cache", false, "Keep downloaded packages in the sandbox for this session")
+	startCmd.Flags().String("timeout", "", "Stop the sandbox after this long (e.g., 30m)")
+	startCmd.Flags().String("idle-timeout", "", "Stop the sandbox after this long without output or CPU activity")
+	rootCmd.AddCommand(startCmd)
+}
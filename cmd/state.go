@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/spf13/cobra"
+)
+
+// pauseCmd represents the pause command
+// It freezes all processes of a running sandbox.
+var pauseCmd = &cobra.Command{
+	Use:   "pause <name>",
+	Short: "Freeze all processes of a running sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		if err := sb.Pause(); err != nil {
+			log.Fatalf("Failed to pause sandbox: %v", err)
+		}
+		log.Printf("Sandbox '%s' paused.", sb.Name)
+	},
+}
+
+// resumeCmd represents the resume command
+// It thaws a sandbox frozen by pause.
+var resumeCmd = &cobra.Command{
+	Use:   "resume <name>",
+	Short: "Resume a paused sandbox",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		if err := sb.Resume(); err != nil {
+			log.Fatalf("Failed to resume sandbox: %v", err)
+		}
+		log.Printf("Sandbox '%s' resumed.", sb.Name)
+	},
+}
+
+// listCmd represents the list command
+// It shows all sandboxes under the base directory with their state.
+var listCmd = &cobra.Command{
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List sandboxes and their state",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, err := sandbox.List(baseDir)
+		if err != nil {
+			log.Fatalf("Failed to list sandboxes: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSTATE\tPERSIST\tPROFILE\tCREATED")
+		for _, md := range all {
+			sb, _ := sandbox.NewSandboxWithBaseDir(md.Name, md.Persist, baseDir)
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", md.Name, sandboxState(sb), md.Persist,
+				profileName(md.Config.Profile), md.Created.Local().Format("2006-01-02 15:04"))
+		}
+		w.Flush()
+	},
+}
+
+// statusCmd represents the status command
+// It prints the state of a single sandbox.
+var statusCmd = &cobra.Command{
+	Use:   "status <name>",
+	Short: "Show whether a sandbox is stopped, running or paused",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		sb, err := sandbox.NewSandboxWithBaseDir(args[0], true, baseDir)
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		md, err := sb.LoadMetadata()
+		if err != nil {
+			log.Fatalf("Failed to load sandbox: %v", err)
+		}
+		fmt.Printf("Name:    %s\n", md.Name)
+		fmt.Printf("State:   %s\n", sandboxState(sb))
+		fmt.Printf("Persist: %t\n", md.Persist)
+		fmt.Printf("Profile: %s\n", profileName(md.Config.Profile))
+		fmt.Printf("Created: %s\n", md.Created.Local().Format("2006-01-02 15:04:05"))
+	},
+}
+
+// sandboxState returns the sandbox's state, or "unknown" if it cannot be determined.
+func sandboxState(sb *sandbox.Sandbox) string {
+	state, err := sb.State()
+	if err != nil {
+		log.Printf("Warning: could not determine state of %s: %v", sb.Name, err)
+		return "unknown"
+	}
+	return state
+}
+
+// profileName returns the profile name to display, resolving the empty default.
+func profileName(name string) string {
+	if name == "" {
+		return isolation.DefaultProfile
+	}
+	return name
+}
+
+func init() {
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(statusCmd)
+}
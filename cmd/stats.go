@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/OminduD/arch-sandbox/utils"
+	"github.com/spf13/cobra"
+)
+
+// diskRefresh is how often stats walks the upper dirs of sandboxes without a disk limit.
+const diskRefresh = 10 * time.Second
+
+// statsCmd represents the stats command
+// It shows live resource usage of running sandboxes.
+var statsCmd = &cobra.Command{
+	Use:   "stats [name...]",
+	Short: "Show live resource usage of running sandboxes",
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval <= 0 {
+			log.Fatalf("Invalid interval %s: must be positive", interval)
+		}
+
+		names := args
+		if len(names) == 0 {
+			all, err := sandbox.List(baseDir)
+			if err != nil {
+				log.Fatalf("Failed to list sandboxes: %v", err)
+			}
+			for _, md := range all {
+				names = append(names, md.Name)
+			}
+		}
+
+		if asJSON {
+			stats := collectStats(names, len(args) > 0, true)
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(stats); err != nil {
+				log.Fatalf("Failed to encode stats: %v", err)
+			}
+			return
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		prev := make(map[string]*sandbox.Stats)
+		var lastWalk time.Time
+		for {
+			// Walking the upper dirs is slow; refresh disk usage less often than the rest.
+			walkDisk := time.Since(lastWalk) >= diskRefresh
+			if walkDisk {
+				lastWalk = time.Now()
+			}
+			stats := collectStats(names, len(args) > 0, walkDisk)
+			for _, st := range stats {
+				if p, ok := prev[st.Name]; ok && !st.DiskMeasured {
+					st.DiskUsage, st.DiskMeasured = p.DiskUsage, p.DiskMeasured
+				}
+			}
+			// Clear the screen and redraw the table in place.
+			fmt.Print("\033[H\033[2J")
+			printStats(stats, prev)
+			for _, st := range stats {
+				prev[st.Name] = st
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// collectStats gathers stats for each named sandbox that is running.
+// Sandboxes the user asked for explicitly are reported when they are not running.
+func collectStats(names []string, explicit, walkDisk bool) []*sandbox.Stats {
+	stats := []*sandbox.Stats{}
+	for _, name := range names {
+		sb, err := sandbox.NewSandboxWithBaseDir(name, true, baseDir)
+		if err != nil {
+			log.Printf("Warning: %s: %v", name, err)
+			continue
+		}
+		st, err := sb.Stats(walkDisk)
+		if err != nil {
+			if explicit || !errors.Is(err, isolation.ErrNotRunning) {
+				log.Printf("Warning: %s: %v", name, err)
+			}
+			continue
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// printStats renders stats as a table. CPU usage is computed from the previous sample,
+// over the time actually elapsed since it was taken.
+func printStats(stats []*sandbox.Stats, prev map[string]*sandbox.Stats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCPU %\tMEM / PEAK\tPIDS\tBLOCK R / W\tNET RX / TX\tDISK")
+	for _, st := range stats {
+		cpu := "-"
+		if p, ok := prev[st.Name]; ok && st.CPUUsageUsec >= p.CPUUsageUsec {
+			if elapsed := st.Time.Sub(p.Time); elapsed > 0 {
+				cpu = fmt.Sprintf("%.1f", float64(st.CPUUsageUsec-p.CPUUsageUsec)/float64(elapsed.Microseconds())*100)
+			}
+		}
+		disk := "-"
+		if st.DiskMeasured {
+			disk = utils.HumanBytes(uint64(st.DiskUsage))
+		}
+		if st.DiskLimit > 0 {
+			disk += " / " + utils.HumanBytes(st.DiskLimit)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s / %s\t%d\t%s / %s\t%s / %s\t%s\n",
+			st.Name, cpu,
+			utils.HumanBytes(st.MemoryCurrent), utils.HumanBytes(st.MemoryPeak),
+			st.PidsCurrent,
+			utils.HumanBytes(st.IOReadBytes), utils.HumanBytes(st.IOWriteBytes),
+			utils.HumanBytes(st.NetRxBytes), utils.HumanBytes(st.NetTxBytes),
+			disk)
+	}
+	w.Flush()
+}
+
+func init() {
+	statsCmd.Flags().Bool("json", false, "Print a single sample as JSON and exit")
+	statsCmd.Flags().Duration("interval", 2*time.Second, "Refresh interval")
+	rootCmd.AddCommand(statsCmd)
+}
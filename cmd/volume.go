@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/sandbox"
+	"github.com/OminduD/arch-sandbox/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// volumeCmd represents the volume command
+// It groups the subcommands that manage named volumes.
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Manage named volumes that outlive sandboxes",
+}
+
+var volumeCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a named volume",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		v, err := sandbox.VolumeStore(baseDir).Create(args[0])
+		if err != nil {
+			log.Fatalf("Failed to create volume: %v", err)
+		}
+		log.Printf("Volume '%s' created at %s.", v.Name, v.Path)
+	},
+}
+
+var volumeListCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List volumes and the sandboxes using them",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		volumes, err := sandbox.VolumeStore(baseDir).List()
+		if err != nil {
+			log.Fatalf("Failed to list volumes: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tCREATED\tUSED BY")
+		for _, v := range volumes {
+			size := "-"
+			if n, err := utils.DirSize(v.Path); err == nil {
+				size = utils.HumanBytes(uint64(n))
+			}
+			users, _ := sandbox.VolumeUsers(baseDir, v.Name)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", v.Name, size, v.Created.Local().Format("2006-01-02 15:04"), strings.Join(users, ", "))
+		}
+		w.Flush()
+	},
+}
+
+var volumeRemoveCmd = &cobra.Command{
+	Use:     "rm <name>",
+	Aliases: []string{"remove"},
+	Short:   "Remove a volume and its data",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		force, _ := cmd.Flags().GetBool("force")
+		users, err := sandbox.VolumeUsers(baseDir, name)
+		if err != nil {
+			log.Fatalf("Failed to remove volume: %v", err)
+		}
+		for _, user := range users {
+			sb, err := sandbox.NewSandboxWithBaseDir(user, true, baseDir)
+			if err != nil {
+				continue
+			}
+			// Mounting a sandbox on the host does not mount its volumes.
+			if state := sandboxState(sb); state != sandbox.StateStopped && state != sandbox.StateMounted && !force {
+				log.Fatalf("Volume '%s' is in use by sandbox '%s' (%s); stop it or use --force", name, user, state)
+			}
+		}
+		if err := sandbox.VolumeStore(baseDir).Remove(name); err != nil {
+			log.Fatalf("Failed to remove volume: %v", err)
+		}
+		log.Printf("Volume '%s' removed.", name)
+	},
+}
+
+// volumeView is the YAML document printed by volume inspect.
+type volumeView struct {
+	Name    string    `yaml:"name"`
+	Path    string    `yaml:"path"`
+	Created time.Time `yaml:"created"`
+	Size    string    `yaml:"size"`
+	UsedBy  []string  `yaml:"used_by"`
+}
+
+var volumeInspectCmd = &cobra.Command{
+	Use:   "inspect <name>",
+	Short: "Show the details of a volume",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		v, err := sandbox.VolumeStore(baseDir).Get(args[0])
+		if err != nil {
+			log.Fatalf("Failed to inspect volume: %v", err)
+		}
+		view := volumeView{Name: v.Name, Path: v.Path, Created: v.Created}
+		if n, err := utils.DirSize(v.Path); err == nil {
+			view.Size = utils.HumanBytes(uint64(n))
+		}
+		if view.UsedBy, err = sandbox.VolumeUsers(baseDir, v.Name); err != nil {
+			log.Fatalf("Failed to inspect volume: %v", err)
+		}
+		out, err := yaml.Marshal(view)
+		if err != nil {
+			log.Fatalf("Failed to inspect volume: %v", err)
+		}
+		os.Stdout.Write(out)
+	},
+}
+
+func init() {
+	volumeRemoveCmd.Flags().BoolP("force", "f", false, "Remove the volume even if a running sandbox uses it")
+	volumeCmd.AddCommand(volumeCreateCmd)
+	volumeCmd.AddCommand(volumeListCmd)
+	volumeCmd.AddCommand(volumeRemoveCmd)
+	volumeCmd.AddCommand(volumeInspectCmd)
+	rootCmd.AddCommand(volumeCmd)
+}
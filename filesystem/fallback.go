@@ -0,0 +1,133 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Ways of providing a sandbox's root filesystem, in order of preference.
+const (
+	ModeOverlay = "overlayfs"      // kernel overlayfs
+	ModeFuse    = "fuse-overlayfs" // overlayfs in user space
+	ModeCopy    = "copy"           // a full copy of the lower dir in a plain directory
+)
+
+// AllModes lists every mode in order of preference.
+var AllModes = []string{ModeOverlay, ModeFuse, ModeCopy}
+
+// Filesystem magic numbers that kernel overlayfs cannot use for its upper dir.
+var unsupportedUpper = map[int64]string{
+	0x794c7630: "overlayfs",
+	0x6969:     "NFS",
+	0x65735546: "FUSE",
+	0xff534d42: "CIFS",
+}
+
+// SetupLayers makes lowerDir, with upperDir on top of it, available at overlayDir using
+// the first of modes that works, and returns the mode used. If overlayDir is already
+// mounted, the mode of the existing mount is returned.
+func SetupLayers(lowerDir, upperDir, workDir, overlayDir string, modes []string) (string, error) {
+	if m, err := FindMount(overlayDir); err != nil {
+		return "", err
+	} else if m != nil {
+		log.Printf("Sandbox filesystem already mounted on %s", overlayDir)
+		if strings.HasPrefix(m.FSType, "fuse") {
+			return ModeFuse, nil
+		}
+		return ModeOverlay, nil
+	}
+
+	var reasons []string
+	for _, mode := range modes {
+		var err error
+		switch mode {
+		case ModeOverlay:
+			if err = OverlaySupport(upperDir); err == nil {
+				err = SetupOverlay(lowerDir, upperDir, workDir, overlayDir)
+			}
+		case ModeFuse:
+			err = setupFuseOverlay(lowerDir, upperDir, workDir, overlayDir)
+		case ModeCopy:
+			err = setupCopy(lowerDir, overlayDir)
+		default:
+			err = fmt.Errorf("unknown mode %q", mode)
+		}
+		if err == nil {
+			return mode, nil
+		}
+		log.Printf("Cannot use %s: %v", mode, err)
+		reasons = append(reasons, fmt.Sprintf("%s: %v", mode, err))
+	}
+	return "", fmt.Errorf("no way to set up the sandbox filesystem on %s (%s)", overlayDir, strings.Join(reasons, "; "))
+}
+
+// OverlaySupport explains why kernel overlayfs cannot be used with upperDir, or returns nil.
+func OverlaySupport(upperDir string) error {
+	data, err := os.ReadFile("/proc/filesystems")
+	if err == nil && !strings.Contains(string(data), "\toverlay\n") {
+		// The module may just not be loaded yet; mounting will load it.
+		if exec.Command("modprobe", "overlay").Run() != nil {
+			return errors.New("the kernel does not support overlayfs")
+		}
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(upperDir, &st); err != nil {
+		return err
+	}
+	if name, ok := unsupportedUpper[int64(st.Type)]; ok {
+		return fmt.Errorf("%s is on %s, which overlayfs cannot use as an upper dir", upperDir, name)
+	}
+	return nil
+}
+
+// setupFuseOverlay mounts lowerDir and upperDir with fuse-overlayfs.
+func setupFuseOverlay(lowerDir, upperDir, workDir, overlayDir string) error {
+	if _, err := exec.LookPath("fuse-overlayfs"); err != nil {
+		return errors.New("fuse-overlayfs is not installed")
+	}
+	log.Println("Setting up fuse-overlayfs")
+	out, err := exec.Command("fuse-overlayfs",
+		"-o", "lowerdir="+lowerDir+",upperdir="+upperDir+",workdir="+workDir, overlayDir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// setupCopy copies lowerDir into overlayDir, using reflinks where the filesystem
+// supports them. A completed copy in overlayDir is left as it is; a partial one,
+// recognised by the marker written next to overlayDir while copying, is redone.
+func setupCopy(lowerDir, overlayDir string) error {
+	if strings.Contains(lowerDir, ":") {
+		return errors.New("a copy cannot be layered on another sandbox")
+	}
+	// The marker is removed once the copy is complete, rather than written then, so
+	// copies made before it existed still count as complete.
+	marker := overlayDir + ".copying"
+	if _, err := os.Stat(marker); err == nil {
+		log.Printf("Removing the incomplete copy in %s", overlayDir)
+		if err := os.RemoveAll(overlayDir); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(overlayDir, 0755); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(filepath.Join(overlayDir, "etc")); err == nil {
+		return nil
+	}
+	if err := os.WriteFile(marker, nil, 0644); err != nil {
+		return err
+	}
+	log.Printf("Copying %s to %s", lowerDir, overlayDir)
+	out, err := exec.Command("cp", "-a", "--reflink=auto", lowerDir+"/.", overlayDir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("copy: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.Remove(marker)
+}
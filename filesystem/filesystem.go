@@ -1,22 +1,189 @@
 package filesystem
 
 import (
+	"errors"
+	"fmt"
 	"log"
-	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
+// UnmountOptions control how a busy mount is handled.
+type UnmountOptions struct {
+	Lazy  bool // detach now and clean up once the mount is no longer in use (MNT_DETACH)
+	Force bool // force the unmount even if busy, where the filesystem supports it (MNT_FORCE)
+}
+
+// SetupOverlay mounts an overlayfs on overlayDir. lowerDir may list several layers
+// separated by ':', topmost first. It does nothing if overlayDir is already a mount
+// point, so setting up a sandbox twice never stacks a second overlay.
 func SetupOverlay(lowerDir, upperDir, workDir, overlayDir string) error {
-	log.Println("Setting up overlayfs")
-	cmd := exec.Command("mount", "-t", "overlay", "overlay",
-		"-o", "lowerdir="+lowerDir+",upperdir="+upperDir+",workdir="+workDir, overlayDir)
-	if err := cmd.Run(); err != nil {
+	mounted, err := IsMounted(overlayDir)
+	if err != nil {
 		return err
 	}
+	if mounted {
+		log.Printf("Overlayfs already mounted on %s", overlayDir)
+		return nil
+	}
+	log.Println("Setting up overlayfs")
+	data := "lowerdir=" + lowerDir + ",upperdir=" + upperDir + ",workdir=" + workDir
+	if err := syscall.Mount("overlay", overlayDir, "overlay", 0, data); err != nil {
+		return &MountError{Op: "mount overlay on", Target: overlayDir, Err: err}
+	}
 	log.Println("Overlayfs mounted")
 	return nil
 }
+
+// UnmountOverlay unmounts the overlayfs on overlayDir. It does nothing if nothing is
+// mounted there.
 func UnmountOverlay(overlayDir string) error {
-	log.Println("Unmounting overlayfs")
-	cmd := exec.Command("umount", overlayDir)
-	return cmd.Run()
+	return Unmount(overlayDir, UnmountOptions{})
+}
+
+// Unmount unmounts target, doing nothing if it is not a mount point. If the mount is
+// busy, the error lists the processes using it.
+func Unmount(target string, opts UnmountOptions) error {
+	mounted, err := IsMounted(target)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return nil
+	}
+	log.Printf("Unmounting %s", target)
+	flags := 0
+	if opts.Lazy {
+		flags |= syscall.MNT_DETACH
+	}
+	if opts.Force {
+		flags |= syscall.MNT_FORCE
+	}
+	if err := syscall.Unmount(target, flags); err != nil {
+		mErr := &MountError{Op: "unmount", Target: target, Err: err}
+		if errors.Is(err, syscall.EBUSY) {
+			mErr.Holders, _ = Holders(target)
+		}
+		return mErr
+	}
+	return nil
+}
+
+// MountError describes a failed mount or unmount, including the kernel's reason and,
+// for busy mounts, the processes holding the mount.
+type MountError struct {
+	Op      string
+	Target  string
+	Err     error
+	Holders []Process
+}
+
+func (e *MountError) Error() string {
+	msg := fmt.Sprintf("%s %s: %v", e.Op, e.Target, e.Err)
+	if len(e.Holders) > 0 {
+		msg += "; in use by"
+		for i, p := range e.Holders {
+			if i > 0 {
+				msg += ","
+			}
+			msg += fmt.Sprintf(" %d (%s)", p.PID, p.Command)
+		}
+	}
+	if errors.Is(e.Err, syscall.EINVAL) && e.Op != "unmount" {
+		msg += " (see dmesg for details from the kernel)"
+	}
+	return msg
+}
+
+func (e *MountError) Unwrap() error { return e.Err }
+
+// cleanPath returns the absolute, symlink-free form of path as it appears in mountinfo.
+func cleanPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	return abs, nil
+}
+
+// MountTmpfs mounts a tmpfs with the given options on target, doing nothing if target
+// is already a mount point.
+func MountTmpfs(target, options string) error {
+	mounted, err := IsMounted(target)
+	if err != nil || mounted {
+		return err
+	}
+	if err := syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, options); err != nil {
+		return &MountError{Op: "mount tmpfs on", Target: target, Err: err}
+	}
+	return nil
+}
+
+// Usage returns the used and total bytes of the filesystem holding path.
+func Usage(path string) (used, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	total = st.Blocks * uint64(st.Bsize)
+	return total - st.Bfree*uint64(st.Bsize), total, nil
+}
+
+// BindMount bind-mounts source on target read-write. It does nothing if target is
+// already such a bind mount of source, and fails if another mount is in the way.
+func BindMount(source, target string) error {
+	m, err := FindMount(target)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		return checkBindMount(m, source, target)
+	}
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND, ""); err != nil {
+		return &MountError{Op: "bind mount " + source + " on", Target: target, Err: err}
+	}
+	return nil
+}
+
+// checkBindMount returns an error unless the existing mount m on target is a
+// read-write bind mount of source.
+func checkBindMount(m *MountInfo, source, target string) error {
+	source, err := cleanPath(source)
+	if err != nil {
+		return err
+	}
+	device, fsPath, err := location(source)
+	if err != nil {
+		return err
+	}
+	if m.Device != device || filepath.Clean(m.Root) != fsPath {
+		return fmt.Errorf("%s is already mounted, but not from %s", target, source)
+	}
+	for _, opt := range strings.Split(m.MountOptions, ",") {
+		if opt == "ro" {
+			return fmt.Errorf("%s is already mounted from %s, but read-only", target, source)
+		}
+	}
+	return nil
+}
+
+// RemountReadOnly makes the bind mount on target read-only.
+func RemountReadOnly(target string) error {
+	if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+		return &MountError{Op: "remount read-only", Target: target, Err: err}
+	}
+	return nil
+}
+
+// MountReadOnlyOverlay mounts a read-only overlayfs of lowerDir, at least two layers
+// separated by ':' and topmost first, on target.
+func MountReadOnlyOverlay(lowerDir, target string) error {
+	if err := syscall.Mount("overlay", target, "overlay", syscall.MS_RDONLY, "lowerdir="+lowerDir); err != nil {
+		return &MountError{Op: "mount read-only overlay on", Target: target, Err: err}
+	}
+	return nil
 }
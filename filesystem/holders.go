@@ -0,0 +1,67 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Process is a process using a mount.
+type Process struct {
+	PID     int
+	Command string
+}
+
+// Holders lists the processes whose root, working directory, open files or mapped
+// files are below path. Processes that cannot be inspected are skipped.
+func Holders(path string) ([]Process, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var holders []Process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if usesPath(filepath.Join("/proc", entry.Name()), path) {
+			comm, _ := os.ReadFile(filepath.Join("/proc", entry.Name(), "comm"))
+			holders = append(holders, Process{PID: pid, Command: strings.TrimSpace(string(comm))})
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool { return holders[i].PID < holders[j].PID })
+	return holders, nil
+}
+
+// usesPath reports whether the process at procDir references a file below path.
+func usesPath(procDir, path string) bool {
+	for _, link := range []string{"root", "cwd", "exe"} {
+		if target, err := os.Readlink(filepath.Join(procDir, link)); err == nil && below(target, path) {
+			return true
+		}
+	}
+	fds, _ := os.ReadDir(filepath.Join(procDir, "fd"))
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join(procDir, "fd", fd.Name())); err == nil && below(target, path) {
+			return true
+		}
+	}
+	maps, _ := os.ReadFile(filepath.Join(procDir, "maps"))
+	for _, line := range strings.Split(string(maps), "\n") {
+		if i := strings.Index(line, "/"); i >= 0 && below(line[i:], path) {
+			return true
+		}
+	}
+	return false
+}
+
+func below(target, path string) bool {
+	return target == path || strings.HasPrefix(target, path+"/")
+}
@@ -0,0 +1,189 @@
+package filesystem
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MountInfo is an entry of /proc/self/mountinfo.
+type MountInfo struct {
+	Device     string // major:minor of the filesystem
+	Root       string // directory of the filesystem mounted, e.g. the source of a bind mount
+	MountPoint string
+	FSType     string
+	Source     string
+	Options    string // per-superblock options, e.g. lowerdir=...,upperdir=...
+	// MountOptions are the per-mount options, e.g. ro,nosuid.
+	MountOptions string
+}
+
+// Mounts returns the mounts of the calling process's mount namespace.
+func Mounts() ([]MountInfo, error) {
+	return readMountInfo("/proc/self/mountinfo")
+}
+
+func readMountInfo(path string) ([]MountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, tail := strings.Fields(pre), strings.Fields(post)
+		if len(fields) < 6 || len(tail) < 3 {
+			continue
+		}
+		mounts = append(mounts, MountInfo{
+			Device:     fields[2],
+			Root:       unescape(fields[3]),
+			MountPoint: unescape(fields[4]),
+			FSType:     tail[0],
+			Source:     unescape(tail[1]),
+			Options:    tail[2],
+
+			MountOptions: fields[5],
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// IsMounted reports whether path is a mount point.
+func IsMounted(path string) (bool, error) {
+	m, err := FindMount(path)
+	return m != nil, err
+}
+
+// FindMount returns the topmost mount on path, or nil if path is not a mount point.
+func FindMount(path string) (*MountInfo, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := Mounts()
+	if err != nil {
+		return nil, err
+	}
+	// Later entries are mounted on top of earlier ones.
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if filepath.Clean(mounts[i].MountPoint) == path {
+			return &mounts[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// unescape decodes the octal escapes (\040 for space, etc.) used in mountinfo.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// InUse reports whether anything is mounted below path or an overlay uses a directory
+// below path as one of its layers.
+func InUse(path string) (bool, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return false, err
+	}
+	mounts, err := Mounts()
+	if err != nil {
+		return false, err
+	}
+	for _, m := range mounts {
+		if below(filepath.Clean(m.MountPoint), path) {
+			return true, nil
+		}
+		if m.FSType != "overlay" {
+			continue
+		}
+		for _, opt := range strings.Split(m.Options, ",") {
+			key, dirs, _ := strings.Cut(opt, "=")
+			if key != "lowerdir" && key != "upperdir" && key != "workdir" {
+				continue
+			}
+			for _, dir := range strings.Split(dirs, ":") {
+				if below(filepath.Clean(unescape(dir)), path) {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// MountedIn reports whether path, or a directory below it, is bind-mounted in the
+// mount namespace of process pid, such as a container's init. Mounts made inside a
+// container do not show up in the host's mount table, so they are matched by the
+// filesystem and directory they expose.
+func MountedIn(pid int, path string) (bool, error) {
+	path, err := cleanPath(path)
+	if err != nil {
+		return false, err
+	}
+	device, fsPath, err := location(path)
+	if err != nil || device == "" {
+		return false, err
+	}
+	mounts, err := readMountInfo("/proc/" + strconv.Itoa(pid) + "/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	for _, m := range mounts {
+		if m.Device == device && below(filepath.Clean(m.Root), fsPath) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// location returns the filesystem holding the clean path, as major:minor, and the
+// path's location within it, which is what mountinfo shows as the root of a bind
+// mount of path. device is empty if no mount holds path.
+func location(path string) (device, fsPath string, err error) {
+	mounts, err := Mounts()
+	if err != nil {
+		return "", "", err
+	}
+	var holder *MountInfo
+	for i := range mounts {
+		mp := filepath.Clean(mounts[i].MountPoint)
+		if below(path, mp) || mp == "/" {
+			if holder == nil || len(mp) >= len(filepath.Clean(holder.MountPoint)) {
+				holder = &mounts[i]
+			}
+		}
+	}
+	if holder == nil {
+		return "", "", nil
+	}
+	rel, err := filepath.Rel(filepath.Clean(holder.MountPoint), path)
+	if err != nil {
+		return "", "", err
+	}
+	return holder.Device, filepath.Join(holder.Root, rel), nil
+}
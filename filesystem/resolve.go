@@ -0,0 +1,58 @@
+package filesystem
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks is how many symlinks ResolveIn follows before giving up, like the kernel.
+const maxSymlinks = 40
+
+// ResolveIn resolves p as if root were the root directory: absolute symlinks and ".."
+// never lead outside of it. The result is a host path below root whose components are
+// not symlinks, so it can be used without following anything the sandbox controls.
+// Components that do not exist are kept as they are.
+func ResolveIn(root, p string) (string, error) {
+	resolved := "/"
+	remaining := p
+	links := 0
+	for remaining != "" {
+		var part string
+		part, remaining, _ = strings.Cut(remaining, "/")
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, part)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if os.IsNotExist(err) {
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: p, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(root, resolved), nil
+}
@@ -0,0 +1,181 @@
+package isolation
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/cgroup"
+)
+
+// Exec runs command in the namespaces of a running container, as user (a user name or
+// UID[:GID], root if empty), in workdir and with the KEY=VALUE variables in env.
+// Without a command it opens a login shell.
+func Exec(m *Machine, user, workdir string, env, command []string) error {
+	if user == "" {
+		user = "root"
+	}
+	var argv []string
+	wd := "/"
+	if IsUserName(user) {
+		// A login shell gives the user their own HOME, PATH and shell environment.
+		argv = []string{"/usr/bin/runuser", "--login", user}
+		script := ""
+		if len(env) > 0 {
+			script = "export " + shellQuote(env) + "; "
+		}
+		if workdir != "" {
+			script += "cd " + shellQuote([]string{workdir}) + " && "
+		}
+		if len(command) > 0 {
+			script += "exec " + shellQuote(command)
+		} else if script != "" {
+			script += "exec \"$SHELL\" -l"
+		}
+		if script != "" {
+			argv = append(argv, "--command="+script)
+		}
+	} else {
+		if len(command) == 0 {
+			command = []string{"/bin/bash"}
+		}
+		wrapped, err := setprivCommand(user, command)
+		if err != nil {
+			return err
+		}
+		if workdir != "" {
+			wd = workdir
+		}
+		argv = append(append([]string{"/usr/bin/env", "HOME=/tmp"}, env...), wrapped...)
+	}
+
+	cmd, release, err := EnterCommand(m, wd, argv...)
+	if err != nil {
+		return err
+	}
+	defer release()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Printf("Executing: %s", cmd.String())
+	return cmd.Run()
+}
+
+// EnterCommand returns a command that runs argv as root in workdir inside a running
+// container: in its cgroup and all its namespaces, including its user namespace, with
+// no more capabilities than its init process, whose bounding set reflects the profile,
+// and under the same no_new_privs flag and syscall filters. release closes the files
+// the command inherits once it has finished. A paused container is refused, since the
+// command would hang until it is resumed.
+func EnterCommand(m *Machine, workdir string, argv ...string) (cmd *exec.Cmd, release func(), err error) {
+	dir := m.ProcessDir()
+	if frozen, err := cgroup.Frozen(dir); err == nil && frozen {
+		return nil, nil, ErrPaused
+	}
+	status, err := readProcessStatus(m.Leader)
+	if err != nil {
+		return nil, nil, err
+	}
+	group, err := os.Open(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open cgroup of %s: %w", m.Name, err)
+	}
+	files := []*os.File{group}
+	release = func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	args := []string{"--target", strconv.Itoa(m.Leader), "--all", "--root", "--wd=" + workdir, "--"}
+	if status.seccomp {
+		// nsenter itself must not run under the filters, which may deny setns or
+		// chroot, so arch-sandbox installs them once it is inside.
+		helper, err := filterHelper(m.Leader)
+		if err != nil {
+			release()
+			return nil, nil, err
+		}
+		files = append(files, helper...)
+		args = append(args, fmt.Sprintf("/proc/self/fd/%d", helperExeFD), EnterHelper, "--")
+	}
+	args = append(append(args, status.privileges()...), argv...)
+	cmd = exec.Command("nsenter", args...)
+	cmd.ExtraFiles = files[1:]
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(group.Fd())}
+	return cmd, release, nil
+}
+
+// capNames are the capability names setpriv understands, indexed by number.
+var capNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill", "setgid",
+	"setuid", "setpcap", "linux_immutable", "net_bind_service", "net_broadcast",
+	"net_admin", "net_raw", "ipc_lock", "ipc_owner", "sys_module", "sys_rawio",
+	"sys_chroot", "sys_ptrace", "sys_pacct", "sys_admin", "sys_boot", "sys_nice",
+	"sys_resource", "sys_time", "sys_tty_config", "mknod", "lease", "audit_write",
+	"audit_control", "setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+// processStatus is what EnterCommand carries over from a container's init process.
+type processStatus struct {
+	bounding   uint64
+	noNewPrivs bool
+	seccomp    bool
+}
+
+// readProcessStatus reads the capability bounding set, no_new_privs flag and seccomp
+// mode of process pid.
+func readProcessStatus(pid int) (processStatus, error) {
+	var st processStatus
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), ":")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "CapBnd":
+			if st.bounding, err = strconv.ParseUint(value, 16, 64); err != nil {
+				return st, fmt.Errorf("parse capability bounding set of process %d: %w", pid, err)
+			}
+			found = true
+		case "NoNewPrivs":
+			st.noNewPrivs = value == "1"
+		case "Seccomp":
+			st.seccomp = value == "2"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return st, err
+	}
+	if !found {
+		return st, fmt.Errorf("no capability bounding set for process %d", pid)
+	}
+	return st, nil
+}
+
+// privileges returns a setpriv command prefix that limits what follows to the
+// capability bounding set and no_new_privs flag of the process.
+func (st processStatus) privileges() []string {
+	caps := []string{"-all"}
+	for i, name := range capNames {
+		if st.bounding&(1<<i) != 0 {
+			caps = append(caps, "+"+name)
+		}
+	}
+	args := []string{"/usr/bin/setpriv", "--bounding-set=" + strings.Join(caps, ","), "--inh-caps=-all"}
+	if st.noNewPrivs {
+		args = append(args, "--no-new-privs")
+	}
+	return append(args, "--")
+}
@@ -0,0 +1,162 @@
+package isolation
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Syscalls and flags for ID-mapped mounts, which the syscall package does not define.
+// The syscall numbers are the same on all architectures that have them.
+const (
+	sysOpenTree     = 428
+	sysMountSetattr = 442
+
+	atFdcwd        = -0x64
+	openTreeClone  = 0x1
+	atEmptyPath    = 0x1000
+	atRecursive    = 0x8000
+	mountAttrIDMap = 0x00100000
+)
+
+const (
+	// minNspawnIDMap is the first systemd release with the idmap bind mount option.
+	minNspawnIDMap = 250
+	// idmapRange is the number of IDs mapped by the probe's user namespace.
+	idmapRange = 65536
+)
+
+// mountAttr is struct mount_attr from linux/mount.h.
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+// idmapProbe checks whether bind mount sources can be ID-mapped by trying it on a
+// detached copy of each mount, which is never attached anywhere.
+type idmapProbe struct {
+	helper *exec.Cmd
+	userns *os.File
+	err    error // why no source can be ID-mapped, if that is known up front
+}
+
+// newIDMapProbe prepares a user namespace to map mounts into. Failures are kept in
+// the probe and reported for every mount it is asked about.
+func newIDMapProbe() *idmapProbe {
+	p := &idmapProbe{}
+	if v, err := nspawnVersion(); err != nil {
+		p.err = fmt.Errorf("cannot determine the systemd-nspawn version: %w", err)
+		return p
+	} else if v < minNspawnIDMap {
+		p.err = fmt.Errorf("systemd-nspawn %d is too old, version %d or later is needed", v, minNspawnIDMap)
+		return p
+	}
+	// A process in a new user namespace, only there for its namespace file.
+	p.helper = exec.Command("sleep", "infinity")
+	p.helper.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: idmapRange}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 0, Size: idmapRange}},
+	}
+	if err := p.helper.Start(); err != nil {
+		p.err = fmt.Errorf("cannot create a user namespace: %w", err)
+		p.helper = nil
+		return p
+	}
+	f, err := os.Open("/proc/" + strconv.Itoa(p.helper.Process.Pid) + "/ns/user")
+	if err != nil {
+		p.err = fmt.Errorf("cannot open user namespace: %w", err)
+		return p
+	}
+	p.userns = f
+	return p
+}
+
+// check reports why path cannot be ID-mapped, or nil if it can.
+func (p *idmapProbe) check(path string) error {
+	if p.err != nil {
+		return p.err
+	}
+	pathPtr, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	dirfd := atFdcwd
+	fd, _, errno := syscall.Syscall(sysOpenTree, uintptr(dirfd), uintptr(unsafe.Pointer(pathPtr)),
+		openTreeClone|syscall.O_CLOEXEC|atRecursive)
+	if errno != 0 {
+		return idmapError("open_tree", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	attr := mountAttr{attrSet: mountAttrIDMap, usernsFd: uint64(p.userns.Fd())}
+	empty := [1]byte{}
+	_, _, errno = syscall.Syscall6(sysMountSetattr, fd, uintptr(unsafe.Pointer(&empty[0])),
+		atEmptyPath|atRecursive, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return idmapError("mount_setattr", errno)
+	}
+	return nil
+}
+
+// idmapError explains a failed ID-mapping syscall.
+func idmapError(op string, errno syscall.Errno) error {
+	switch {
+	case errors.Is(errno, syscall.ENOSYS):
+		return fmt.Errorf("%s: the kernel does not support ID-mapped mounts (Linux 5.12 or later is needed)", op)
+	case errors.Is(errno, syscall.EINVAL):
+		return fmt.Errorf("%s: the filesystem does not support ID-mapped mounts", op)
+	case errors.Is(errno, syscall.EPERM):
+		return fmt.Errorf("%s: not permitted (ID-mapped mounts need root)", op)
+	}
+	return fmt.Errorf("%s: %w", op, errno)
+}
+
+func (p *idmapProbe) close() {
+	if p.userns != nil {
+		p.userns.Close()
+	}
+	if p.helper != nil {
+		p.helper.Process.Kill()
+		p.helper.Wait()
+	}
+}
+
+// nspawnVersion returns the major version of systemd-nspawn, e.g. 255.
+func nspawnVersion() (int, error) {
+	out, err := exec.Command("systemd-nspawn", "--version").Output()
+	if err != nil {
+		return 0, err
+	}
+	// systemd 255 (255.4-1-arch)
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 || fields[0] != "systemd" {
+		return 0, fmt.Errorf("unexpected output %q", strings.TrimSpace(string(out)))
+	}
+	return strconv.Atoi(fields[1])
+}
+
+// mapMount reports whether the bind mount m can keep its host ownership inside a
+// container with private users. Without an ID mapping, files owned by host users
+// show up as owned by nobody; a mount that cannot be mapped is left as a plain bind
+// mount, with a warning saying why.
+func (p *idmapProbe) mapMount(m Mount) bool {
+	err := p.check(m.Source)
+	if err == nil {
+		return true
+	}
+	source := m.Source
+	if m.Sensitive {
+		source = "<redacted>"
+	}
+	log.Printf("Warning: mount %s: files from %s will appear as owned by nobody: %v", m.Target, source, err)
+	return false
+}
@@ -1,67 +1,268 @@
 package isolation
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
+	"syscall"
+	"time"
 )
 
+// Options holds everything needed to build the systemd-nspawn command line.
+type Options struct {
+	Directory     string
+	Machine       string
+	NetworkMode   string
+	NetworkBridge string // host bridge to attach the container's veth to, if any
+	DNS           []string
+	Ports         []string
+	Mounts        []Mount
+	Resources     Resources
+	Profile       Profile
+	Timeout       time.Duration // wall-clock limit for the session, 0 for none
+	IdleTimeout   time.Duration // limit on time without output or CPU usage, 0 for none
+	Command       []string      // command to run in the container, /bin/bash if empty
+	Chdir         string        // working directory of the command inside the container
+	User          string        // run the command as a user name or UID[:GID] instead of root
+	Env           []string      // extra KEY=VALUE environment variables
+	Hostname      string        // hostname inside the container, the machine name if empty
+	Timezone      string        // systemd-nspawn --timezone mode, its default if empty
+	// OnStart, if set, runs once the container is registered with systemd-machined,
+	// e.g. to configure its network from the host. The command waits for it to return
+	// and does not run if it fails; an error stops the container.
+	OnStart func(m *Machine) error
+}
+
+// registerTimeout bounds how long OnStart waits for the container to show up in systemd-machined.
+const registerTimeout = 30 * time.Second
+
 // LaunchNspawn constructs and executes the systemd-nspawn command to start the container.
-func LaunchNspawn(overlayDir, name, networkMode string, dns []string, ports []string, cpuShares, memoryLimit string) error {
-	log.Printf("Launching systemd-nspawn for %s", name)
+// Cancelling ctx, or hitting one of the timeouts, stops the container gracefully with
+// SIGTERM and kills it if it is still running after a grace period.
+func LaunchNspawn(ctx context.Context, opts Options) error {
+	log.Printf("Launching systemd-nspawn for %s", opts.Machine)
 
 	args := []string{
-		"--directory", overlayDir,
-		"--machine", name,
+		"--directory", opts.Directory,
+		"--machine", opts.Machine,
 	}
 
 	// Configure networking
-	switch networkMode {
-	case "private":
+	switch {
+	case opts.NetworkBridge != "":
+		args = append(args, "--network-bridge="+opts.NetworkBridge)
+	case opts.NetworkMode == "private":
 		args = append(args, "--network-veth")
-	case "none":
+	case opts.NetworkMode == "none":
 		args = append(args, "--private-network")
-	case "host":
+	case opts.NetworkMode == "host", opts.NetworkMode == "":
 		// This is the default behavior, no extra flag needed.
 	default:
-		log.Printf("Warning: unknown network mode %q, using systemd-nspawn default (host)", networkMode)
+		log.Printf("Warning: unknown network mode %q, using systemd-nspawn default (host)", opts.NetworkMode)
 	}
 
 	// Configure DNS if provided
-	if len(dns) > 0 {
+	if len(opts.DNS) > 0 {
 		args = append(args, "--resolv-conf=off")
-		for _, d := range dns {
+		for _, d := range opts.DNS {
 			args = append(args, "--dns="+d)
 		}
 	}
 
 	// Configure port mappings
-	for _, p := range ports {
+	for _, p := range opts.Ports {
 		args = append(args, "--port="+p)
 	}
 
-	// Configure resource limits
-	if cpuShares != "" {
-		// systemd-nspawn uses --cpu-weight for shares
-		args = append(args, fmt.Sprintf("--cpu-weight=%s", cpuShares))
+	// Configure bind and tmpfs mounts. The sources of sensitive mounts are left out of the log.
+	// With private users, bind mounts are ID-mapped where the kernel and filesystem allow.
+	redact := map[string]string{}
+	var probe *idmapProbe
+	for _, m := range opts.Mounts {
+		if m.Type == MountVolume {
+			return fmt.Errorf("mount %s: volume %q was not resolved to a path", m.Target, m.Source)
+		}
+		if err := m.Validate(); err != nil {
+			return err
+		}
+		if err := m.CheckSource(); err != nil {
+			return err
+		}
+		if opts.Profile.PrivateUsers && m.Type != MountTmpfs {
+			if probe == nil {
+				probe = newIDMapProbe()
+				defer probe.close()
+			}
+			m.IDMap = probe.mapMount(m)
+		}
+		for i, arg := range m.Args() {
+			if m.Sensitive {
+				redact[arg] = m.LogArgs()[i]
+			}
+			args = append(args, arg)
+		}
+	}
+
+	// Configure resource limits as properties of the container's cgroup scope
+	if err := opts.Resources.Validate(); err != nil {
+		return err
+	}
+	args = append(args, opts.Resources.Args()...)
+
+	// Apply the security profile
+	args = append(args, opts.Profile.Args()...)
+	if opts.Profile.PrivateUsers {
+		if probe == nil {
+			probe = newIDMapProbe()
+			defer probe.close()
+		}
+		if err := probe.check(opts.Directory); err != nil {
+			log.Printf("Warning: the root filesystem cannot be ID-mapped (%v); systemd-nspawn will chown it instead, which copies every file into the sandbox's upper dir", err)
+		}
 	}
 
-	if memoryLimit != "" {
-		// systemd-nspawn uses --memory-max for limit
-		args = append(args, fmt.Sprintf("--memory-max=%s", memoryLimit))
+	// Forward SIGTERM to the container's init instead of the default SIGKILL,
+	// so a cancelled session gets a chance to shut down cleanly.
+	args = append(args, "--kill-signal=SIGTERM")
+
+	if opts.User != "" && IsUserName(opts.User) {
+		args = append(args, "--user="+opts.User)
+	}
+	if opts.Hostname != "" {
+		args = append(args, "--hostname="+opts.Hostname)
+	}
+	if opts.Timezone != "" {
+		args = append(args, "--timezone="+opts.Timezone)
+	}
+	if opts.Chdir != "" {
+		args = append(args, "--chdir="+opts.Chdir)
+	}
+	for _, e := range opts.Env {
+		args = append(args, "--setenv="+e)
 	}
 
 	// The command to run inside the container
-	args = append(args, "/bin/bash")
+	command, err := opts.command()
+	if err != nil {
+		return err
+	}
+	var ready *readiness
+	if opts.OnStart != nil {
+		if ready, err = newReadiness(); err != nil {
+			return fmt.Errorf("set up start synchronization: %w", err)
+		}
+		defer ready.close()
+		args = append(args, ready.bindArg())
+		command = ready.wrap(command)
+	}
+	args = append(args, command...)
 
-	cmd := exec.Command("systemd-nspawn", args...)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if opts.Timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, opts.Timeout, ErrTimeout)
+		defer stop()
+	}
+
+	cmd := exec.CommandContext(ctx, "systemd-nspawn", args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = killGrace
 
 	// Make the session interactive
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	log.Printf("Executing: %s", cmd.String())
-	return cmd.Run()
+	if opts.IdleTimeout > 0 {
+		act := newActivity()
+		// Output only counts as activity when it is not a terminal: wrapping a tty
+		// in a pipe would stop systemd-nspawn from running an interactive console.
+		if !isTerminal(os.Stdout) {
+			cmd.Stdout = &activityWriter{w: os.Stdout, a: act}
+			cmd.Stderr = &activityWriter{w: os.Stderr, a: act}
+		}
+		go watchIdle(ctx, cancel, opts.Machine, opts.IdleTimeout, act)
+	}
+
+	log.Printf("Executing: %s", redactedCommand(cmd, redact))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if opts.OnStart != nil {
+		go func() {
+			m, err := waitForMachine(ctx, opts.Machine)
+			if err == nil {
+				err = opts.OnStart(m)
+			}
+			ready.signal(err == nil)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error: %v", err)
+				cancel(err)
+			}
+		}()
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		terminateMachine(opts.Machine)
+		cause := context.Cause(ctx)
+		if errors.Is(cause, ErrTimeout) || errors.Is(cause, ErrIdleTimeout) {
+			return cause
+		}
+		return fmt.Errorf("sandbox session cancelled: %w", cause)
+	}
+	return err
+}
+
+// redactedCommand renders cmd for logging with the arguments in redact replaced.
+func redactedCommand(cmd *exec.Cmd, redact map[string]string) string {
+	if len(redact) == 0 {
+		return cmd.String()
+	}
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		if r, ok := redact[arg]; ok {
+			arg = r
+		}
+		args[i] = arg
+	}
+	return cmd.Path + " " + strings.Join(args[1:], " ")
+}
+
+// command returns the command line run inside the container. Named users are handled
+// by systemd-nspawn itself; a bare UID is switched to with setpriv.
+func (opts Options) command() ([]string, error) {
+	command := opts.Command
+	if len(command) == 0 {
+		command = []string{"/bin/bash"}
+	}
+	if opts.User == "" || IsUserName(opts.User) {
+		return command, nil
+	}
+	return setprivCommand(opts.User, command)
+}
+
+// waitForMachine polls systemd-machined until the container is registered.
+func waitForMachine(ctx context.Context, name string) (*Machine, error) {
+	deadline := time.Now().Add(registerTimeout)
+	for {
+		m, err := LookupMachine(name)
+		if err == nil {
+			return m, nil
+		}
+		if !errors.Is(err, ErrNotRunning) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("container %s did not register: %w", name, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
 }
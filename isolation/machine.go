@@ -0,0 +1,69 @@
+package isolation
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/OminduD/arch-sandbox/cgroup"
+)
+
+// ErrNotRunning is returned when a machine is not registered with systemd-machined.
+var ErrNotRunning = errors.New("sandbox is not running")
+
+// ErrPaused is returned when a command cannot be run in a paused container.
+var ErrPaused = errors.New("sandbox is paused, resume it first")
+
+// Machine describes a running container as registered with systemd-machined.
+type Machine struct {
+	Name         string
+	Leader       int    // PID of the container's init process on the host
+	ControlGroup string // cgroup path relative to the cgroup2 mount
+}
+
+// ProcessDir returns the cgroup holding the container's processes: its payload child
+// cgroup when systemd-nspawn splits its scope, which keeps the supervisor out of it.
+func (m *Machine) ProcessDir() string {
+	dir := cgroup.Path(m.ControlGroup)
+	if _, err := os.Stat(filepath.Join(dir, "payload")); err == nil {
+		return filepath.Join(dir, "payload")
+	}
+	return dir
+}
+
+// LookupMachine asks systemd-machined for the running container with the given name.
+func LookupMachine(name string) (*Machine, error) {
+	out, err := exec.Command("machinectl", "show", name, "--property=Leader", "--property=ControlGroup").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("machinectl show %s: %w", name, err)
+	}
+
+	m := &Machine{Name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Leader":
+			m.Leader, _ = strconv.Atoi(value)
+		case "ControlGroup":
+			m.ControlGroup = value
+		}
+	}
+	if m.Leader == 0 {
+		return nil, ErrNotRunning
+	}
+	return m, nil
+}
@@ -0,0 +1,148 @@
+package isolation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount types.
+const (
+	MountBind   = "bind"
+	MountTmpfs  = "tmpfs"
+	MountVolume = "volume"
+)
+
+// Mount is a filesystem made available inside the container when it is launched.
+// Mounts live only in the container's mount namespace, so nothing leaks into the
+// host mount table and nothing is written to the overlay's upper dir.
+type Mount struct {
+	Type     string `yaml:"type"`   // bind (default), tmpfs or volume
+	Source   string `yaml:"source"` // host path for bind mounts, volume name for volumes
+	Target   string `yaml:"target"` // absolute path inside the container
+	ReadOnly bool   `yaml:"read_only"`
+	Options  string `yaml:"options"` // tmpfs mount options, e.g. size=64M,mode=1777
+	// Sensitive hides the source in logged command lines, e.g. for secrets.
+	Sensitive bool `yaml:"-"`
+	// IDMap maps host UIDs and GIDs one to one into a container with private users.
+	IDMap bool `yaml:"-"`
+}
+
+// ParseVolume parses a --volume argument of the form src:dst[:ro|rw]. A source that
+// is not a path (does not start with "/" or ".") names a volume.
+func ParseVolume(spec string) (Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Mount{}, fmt.Errorf("invalid volume %q: expected src:dst[:ro|rw]", spec)
+	}
+	m := Mount{Type: MountBind, Source: parts[0], Target: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return Mount{}, fmt.Errorf("invalid volume %q: mode must be ro or rw", spec)
+		}
+	}
+	if strings.HasPrefix(m.Source, "/") || strings.HasPrefix(m.Source, ".") {
+		abs, err := filepath.Abs(m.Source)
+		if err != nil {
+			return Mount{}, err
+		}
+		m.Source = abs
+	} else {
+		m.Type = MountVolume
+	}
+	return m, m.Validate()
+}
+
+// ParseTmpfs parses a --tmpfs-mount argument of the form dst[:options].
+func ParseTmpfs(spec string) (Mount, error) {
+	target, options, _ := strings.Cut(spec, ":")
+	m := Mount{Type: MountTmpfs, Target: target, Options: options}
+	return m, m.Validate()
+}
+
+// Validate checks the mount's type and that its target stays inside the container's root.
+// It does not touch the host; see CheckSource.
+func (m Mount) Validate() error {
+	switch m.Type {
+	case "", MountBind, MountVolume:
+		if m.Source == "" {
+			return fmt.Errorf("mount %s: missing source", m.Target)
+		}
+	case MountTmpfs:
+		if m.Source != "" {
+			return fmt.Errorf("tmpfs mount %s: tmpfs mounts take no source", m.Target)
+		}
+	default:
+		return fmt.Errorf("mount %s: unknown type %q", m.Target, m.Type)
+	}
+	if m.Type == MountBind && !filepath.IsAbs(m.Source) {
+		return fmt.Errorf("bind mount %s: source %q must be an absolute path", m.Target, m.Source)
+	}
+	if !filepath.IsAbs(m.Target) {
+		return fmt.Errorf("mount target %q must be an absolute path", m.Target)
+	}
+	for _, elem := range strings.Split(m.Target, "/") {
+		if elem == ".." {
+			return fmt.Errorf("mount target %q must not contain '..'", m.Target)
+		}
+	}
+	if filepath.Clean(m.Target) == "/" {
+		return fmt.Errorf("mount target must not be the container's root")
+	}
+	return nil
+}
+
+// CheckSource verifies that the source of a bind mount exists on the host.
+func (m Mount) CheckSource() error {
+	if m.Type == MountTmpfs {
+		return nil
+	}
+	if _, err := os.Stat(m.Source); err != nil {
+		return fmt.Errorf("mount %s: source: %w", m.Target, err)
+	}
+	return nil
+}
+
+// Args returns the systemd-nspawn argument for the mount. Volumes must have been
+// resolved to bind mounts first.
+func (m Mount) Args() []string {
+	target := filepath.Clean(m.Target)
+	switch m.Type {
+	case MountTmpfs:
+		arg := "--tmpfs=" + escapeColons(target)
+		if m.Options != "" {
+			arg += ":" + m.Options
+		}
+		return []string{arg}
+	default:
+		flag := "--bind="
+		if m.ReadOnly {
+			flag = "--bind-ro="
+		}
+		arg := flag + escapeColons(m.Source) + ":" + escapeColons(target)
+		if m.IDMap {
+			arg += ":idmap"
+		}
+		return []string{arg}
+	}
+}
+
+// LogArgs returns Args with the source of a sensitive mount redacted.
+func (m Mount) LogArgs() []string {
+	if !m.Sensitive {
+		return m.Args()
+	}
+	redacted := m
+	redacted.Source = "<redacted>"
+	return redacted.Args()
+}
+
+// escapeColons escapes ':' in a path for systemd-nspawn's SRC:DST syntax.
+func escapeColons(path string) string {
+	return strings.ReplaceAll(path, ":", `\:`)
+}
@@ -0,0 +1,130 @@
+package isolation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile describes the hardening applied to a systemd-nspawn container.
+type Profile struct {
+	Name             string   `yaml:"name"`
+	Description      string   `yaml:"description"`
+	DropCapabilities []string `yaml:"drop_capabilities,omitempty"`
+	DeniedSyscalls   []string `yaml:"denied_syscalls,omitempty"`
+	ReadOnly         bool     `yaml:"read_only"`
+	NoNewPrivileges  bool     `yaml:"no_new_privileges"`
+	PrivateTmp       bool     `yaml:"private_tmp"`
+	PrivateUsers     bool     `yaml:"private_users"`
+	// Devices is "default" to keep the nspawn device policy or "minimal" to
+	// only allow the standard pseudo devices (/dev/null, /dev/zero, ...).
+	Devices string `yaml:"devices"`
+}
+
+// DefaultProfile is used when no profile is selected.
+const DefaultProfile = "default"
+
+// profiles holds the built-in security profiles, keyed by name.
+var profiles = map[string]Profile{
+	"default": {
+		Name:        "default",
+		Description: "systemd-nspawn defaults, no extra hardening",
+		Devices:     "default",
+	},
+	"strict": {
+		Name:        "strict",
+		Description: "reduced capabilities, syscall filter and user namespacing",
+		DropCapabilities: []string{
+			"CAP_AUDIT_CONTROL", "CAP_LINUX_IMMUTABLE", "CAP_MKNOD", "CAP_NET_RAW",
+			"CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_MODULE", "CAP_SYS_NICE",
+			"CAP_SYS_PTRACE", "CAP_SYS_RESOURCE", "CAP_SYS_TTY_CONFIG",
+		},
+		DeniedSyscalls:  []string{"@clock", "@cpu-emulation", "@debug", "@module", "@obsolete", "@raw-io", "@reboot", "@swap"},
+		NoNewPrivileges: true,
+		PrivateTmp:      true,
+		PrivateUsers:    true,
+		Devices:         "minimal",
+	},
+	"untrusted": {
+		Name:        "untrusted",
+		Description: "read-only root for running untrusted code such as AUR PKGBUILDs",
+		DropCapabilities: []string{
+			"CAP_AUDIT_CONTROL", "CAP_AUDIT_WRITE", "CAP_IPC_OWNER", "CAP_LEASE",
+			"CAP_LINUX_IMMUTABLE", "CAP_MKNOD", "CAP_NET_ADMIN", "CAP_NET_BIND_SERVICE",
+			"CAP_NET_BROADCAST", "CAP_NET_RAW", "CAP_SETFCAP", "CAP_SETPCAP",
+			"CAP_SYS_ADMIN", "CAP_SYS_BOOT", "CAP_SYS_CHROOT", "CAP_SYS_MODULE",
+			"CAP_SYS_NICE", "CAP_SYS_PTRACE", "CAP_SYS_RESOURCE", "CAP_SYS_TTY_CONFIG",
+		},
+		DeniedSyscalls:  []string{"@clock", "@cpu-emulation", "@debug", "@module", "@mount", "@obsolete", "@raw-io", "@reboot", "@swap"},
+		ReadOnly:        true,
+		NoNewPrivileges: true,
+		PrivateTmp:      true,
+		PrivateUsers:    true,
+		Devices:         "minimal",
+	},
+}
+
+// minimalDevices is the DeviceAllow list of the "minimal" device policy: the standard
+// pseudo devices and terminals.
+var minimalDevices = []string{
+	"/dev/null rw", "/dev/zero rw", "/dev/full rw", "/dev/random rw", "/dev/urandom rw",
+	"/dev/tty rw", "/dev/ptmx rw", "char-pts rw",
+}
+
+// LookupProfile returns the built-in profile with the given name.
+// An empty name selects the default profile.
+func LookupProfile(name string) (Profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown security profile %q (available: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return p, nil
+}
+
+// ProfileNames returns the names of the built-in profiles in sorted order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Args returns the systemd-nspawn arguments that enforce the profile.
+func (p Profile) Args() []string {
+	var args []string
+	if len(p.DropCapabilities) > 0 {
+		args = append(args, "--drop-capability="+strings.Join(p.DropCapabilities, ","))
+	}
+	if len(p.DeniedSyscalls) > 0 {
+		args = append(args, "--system-call-filter=~"+strings.Join(p.DeniedSyscalls, " "))
+	}
+	if p.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	if p.NoNewPrivileges {
+		args = append(args, "--no-new-privileges=yes")
+	}
+	if p.PrivateTmp {
+		args = append(args, "--tmpfs=/tmp")
+	}
+	if p.PrivateUsers {
+		// "auto" uses an ID-mapped mount of the root where the kernel and filesystem
+		// support it, and chowns the whole tree otherwise, which copies every file up
+		// into the overlay. LaunchNspawn warns about the latter.
+		args = append(args, "--private-users=pick", "--private-users-ownership=auto")
+	}
+	if p.Devices == "minimal" {
+		// nspawn already closes the device policy but also allows devices such as
+		// /dev/net/tun and /dev/fuse. An empty DeviceAllow= resets its list.
+		args = append(args, "--property=DeviceAllow=")
+		for _, dev := range minimalDevices {
+			args = append(args, "--property=DeviceAllow="+dev)
+		}
+	}
+	return args
+}
@@ -0,0 +1,66 @@
+package isolation
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// readyPath is where the container's command waits for OnStart to finish.
+const readyPath = "/run/arch-sandbox-ready"
+
+// readiness holds back the container's command until the host has set it up: a FIFO
+// bind-mounted into the container that the command reads "ok" from before it starts.
+type readiness struct {
+	dir  string
+	fifo *os.File
+}
+
+func newReadiness() (*readiness, error) {
+	dir, err := os.MkdirTemp("", "arch-sandbox-ready-")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "ready")
+	if err := syscall.Mkfifo(path, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	// Opened for reading and writing so that opening never blocks, and what is
+	// written stays buffered until the command reads it.
+	fifo, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &readiness{dir: dir, fifo: fifo}, nil
+}
+
+// bindArg returns the systemd-nspawn argument that makes the FIFO visible inside.
+func (r *readiness) bindArg() string {
+	return "--bind-ro=" + filepath.Join(r.dir, "ready") + ":" + readyPath
+}
+
+// wrap returns command preceded by the wait for the host.
+func (r *readiness) wrap(command []string) []string {
+	script := `IFS= read -r state < ` + readyPath + ` && [ "$state" = ok ] || exit 1; exec "$@"`
+	return append([]string{"/bin/sh", "-c", script, "sh"}, command...)
+}
+
+// signal lets the command start, or makes it exit if the setup failed.
+func (r *readiness) signal(ok bool) {
+	state := "failed\n"
+	if ok {
+		state = "ok\n"
+	}
+	r.fifo.WriteString(state)
+}
+
+func (r *readiness) close() {
+	r.fifo.Close()
+	os.RemoveAll(r.dir)
+}
@@ -0,0 +1,145 @@
+package isolation
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Resources holds the cgroup v2 limits applied to a container's scope.
+// Values use systemd's syntax so they can be passed straight through as unit properties.
+type Resources struct {
+	CPUWeight     string `yaml:"cpu_weight"`      // 1-10000, relative CPU share
+	CPUs          string `yaml:"cpus"`            // CPU quota in CPUs, e.g. 1.5
+	CPUSet        string `yaml:"cpuset"`          // CPUs the container may run on, e.g. 0-3,6
+	PidsMax       string `yaml:"pids_max"`        // maximum number of tasks
+	MemoryMax     string `yaml:"memory_max"`      // hard memory limit, e.g. 1G
+	MemoryHigh    string `yaml:"memory_high"`     // memory throttling threshold
+	MemorySwapMax string `yaml:"memory_swap_max"` // swap limit
+	IOWeight      string `yaml:"io_weight"`       // 1-10000, relative IO share
+	// Per-device bandwidth limits in the form "/dev/sda:10M".
+	IOReadBandwidth  []string `yaml:"io_read_bandwidth"`
+	IOWriteBandwidth []string `yaml:"io_write_bandwidth"`
+}
+
+// maxCPUs bounds the cpus limit well above any real machine.
+const maxCPUs = 4096
+
+var (
+	sizeRe   = regexp.MustCompile(`^(\d+(\.\d+)?[KMGT]?|\d+%|infinity)$`)
+	cpuSetRe = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+)
+
+// Validate checks every limit that is set and returns the first invalid one.
+func (r Resources) Validate() error {
+	if err := validateRange("cpu weight", r.CPUWeight, 1, 10000); err != nil {
+		return err
+	}
+	if r.CPUs != "" {
+		cpus, err := strconv.ParseFloat(r.CPUs, 64)
+		// CPUQuota is set in whole percent of a CPU.
+		if err != nil || math.IsNaN(cpus) || math.IsInf(cpus, 0) || cpus < 0.01 || cpus > maxCPUs {
+			return fmt.Errorf("invalid cpus %q: must be between 0.01 and %d", r.CPUs, maxCPUs)
+		}
+	}
+	if r.CPUSet != "" && !cpuSetRe.MatchString(r.CPUSet) {
+		return fmt.Errorf("invalid cpuset %q: expected a list like 0-3,6", r.CPUSet)
+	}
+	if r.PidsMax != "" && r.PidsMax != "infinity" {
+		if err := validateRange("pids max", r.PidsMax, 1, 1<<22); err != nil {
+			return err
+		}
+	}
+	for name, v := range map[string]string{"memory max": r.MemoryMax, "memory high": r.MemoryHigh, "memory swap max": r.MemorySwapMax} {
+		if v != "" && !sizeRe.MatchString(v) {
+			return fmt.Errorf("invalid %s %q: expected a size like 512M, 2G, a percentage or infinity", name, v)
+		}
+	}
+	if err := validateRange("io weight", r.IOWeight, 1, 10000); err != nil {
+		return err
+	}
+	for _, limit := range append(append([]string{}, r.IOReadBandwidth...), r.IOWriteBandwidth...) {
+		if _, _, err := parseBandwidth(limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Args returns the systemd-nspawn arguments that apply the limits to the container's scope.
+func (r Resources) Args() []string {
+	var props []string
+	if r.CPUWeight != "" {
+		props = append(props, "CPUWeight="+r.CPUWeight)
+	}
+	if r.CPUs != "" {
+		cpus, _ := strconv.ParseFloat(r.CPUs, 64)
+		// A quota above the number of CPUs cannot be used up.
+		cpus = math.Min(cpus, float64(runtime.NumCPU()))
+		props = append(props, fmt.Sprintf("CPUQuota=%d%%", int(math.Round(cpus*100))))
+	}
+	if r.CPUSet != "" {
+		props = append(props, "AllowedCPUs="+r.CPUSet)
+	}
+	if r.PidsMax != "" {
+		props = append(props, "TasksMax="+r.PidsMax)
+	}
+	if r.MemoryMax != "" {
+		props = append(props, "MemoryMax="+r.MemoryMax)
+	}
+	if r.MemoryHigh != "" {
+		props = append(props, "MemoryHigh="+r.MemoryHigh)
+	}
+	if r.MemorySwapMax != "" {
+		props = append(props, "MemorySwapMax="+r.MemorySwapMax)
+	}
+	if r.IOWeight != "" {
+		props = append(props, "IOWeight="+r.IOWeight)
+	}
+	for _, limit := range r.IOReadBandwidth {
+		dev, rate, _ := parseBandwidth(limit)
+		props = append(props, "IOReadBandwidthMax="+dev+" "+rate)
+	}
+	for _, limit := range r.IOWriteBandwidth {
+		dev, rate, _ := parseBandwidth(limit)
+		props = append(props, "IOWriteBandwidthMax="+dev+" "+rate)
+	}
+
+	args := make([]string, 0, len(props))
+	for _, p := range props {
+		args = append(args, "--property="+p)
+	}
+	return args
+}
+
+// validateRange checks that v, if set, is an integer within [min, max].
+func validateRange(name, v string, min, max int) error {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		return fmt.Errorf("invalid %s %q: must be an integer between %d and %d", name, v, min, max)
+	}
+	return nil
+}
+
+// parseBandwidth splits a "device:rate" limit and checks both halves.
+func parseBandwidth(limit string) (device, rate string, err error) {
+	i := strings.LastIndex(limit, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid bandwidth limit %q: expected device:rate, e.g. /dev/sda:10M", limit)
+	}
+	device, rate = limit[:i], limit[i+1:]
+	if !sizeRe.MatchString(rate) || strings.HasSuffix(rate, "%") {
+		return "", "", fmt.Errorf("invalid bandwidth limit %q: rate must be a size like 10M", limit)
+	}
+	if _, err := os.Stat(device); err != nil {
+		return "", "", fmt.Errorf("invalid bandwidth limit %q: %v", limit, err)
+	}
+	return device, rate, nil
+}
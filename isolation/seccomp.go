@@ -0,0 +1,162 @@
+package isolation
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// EnterHelper is the hidden arch-sandbox command that EnterCommand runs inside a
+// container to install the syscall filters of its init process before the command.
+const EnterHelper = "enter-filtered"
+
+// The files EnterCommand passes to the helper: the arch-sandbox executable, which is
+// not reachable from inside the container, and the filters to install.
+const (
+	helperExeFD     = 3
+	helperFiltersFD = 4
+)
+
+const (
+	ptraceSeize            = 0x4206
+	ptraceInterrupt        = 0x4207
+	ptraceSeccompGetFilter = 0x420c
+	prSetSeccomp           = 22
+	seccompModeFilter      = 2
+)
+
+// filterHelper returns the files EnterCommand passes to the helper for the filters of
+// process pid.
+func filterHelper(pid int) ([]*os.File, error) {
+	filters, err := seccompFilters(pid)
+	if err != nil {
+		return nil, err
+	}
+	exe, err := os.Open("/proc/self/exe")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "arch-sandbox-filters-*")
+	if err != nil {
+		exe.Close()
+		return nil, err
+	}
+	os.Remove(f.Name())
+	for _, prog := range filters {
+		if err = binary.Write(f, binary.NativeEndian, uint16(len(prog))); err == nil {
+			err = binary.Write(f, binary.NativeEndian, prog)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		exe.Close()
+		f.Close()
+		return nil, fmt.Errorf("write syscall filters: %w", err)
+	}
+	return []*os.File{exe, f}, nil
+}
+
+// seccompFilters returns the seccomp filters of process pid in the order they were
+// installed. The process is stopped with ptrace while they are read.
+func seccompFilters(pid int) ([][]syscall.SockFilter, error) {
+	// ptrace requests must come from the thread that attached.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := ptrace(ptraceSeize, pid, 0, 0); err != nil {
+		return nil, fmt.Errorf("attach to process %d to read its syscall filters: %w", pid, err)
+	}
+	defer ptrace(syscall.PTRACE_DETACH, pid, 0, 0)
+	if err := ptrace(ptraceInterrupt, pid, 0, 0); err != nil {
+		return nil, fmt.Errorf("stop process %d: %w", pid, err)
+	}
+	var ws syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &ws, syscall.WALL, nil)
+		if err == nil {
+			break
+		}
+		if err != syscall.EINTR {
+			return nil, fmt.Errorf("wait for process %d: %w", pid, err)
+		}
+	}
+
+	// Filter 0 is the one installed first.
+	var filters [][]syscall.SockFilter
+	for i := 0; ; i++ {
+		n, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, ptraceSeccompGetFilter, uintptr(pid), uintptr(i), 0, 0, 0)
+		if errno == syscall.ENOENT {
+			return filters, nil
+		}
+		if errno != 0 || n == 0 {
+			return nil, fmt.Errorf("read syscall filter %d of process %d: %w", i, pid, errno)
+		}
+		prog := make([]syscall.SockFilter, n)
+		_, _, errno = syscall.Syscall6(syscall.SYS_PTRACE, ptraceSeccompGetFilter, uintptr(pid), uintptr(i), uintptr(unsafe.Pointer(&prog[0])), 0, 0)
+		if errno != 0 {
+			return nil, fmt.Errorf("read syscall filter %d of process %d: %w", i, pid, errno)
+		}
+		filters = append(filters, prog)
+	}
+}
+
+func ptrace(request, pid int, addr, data uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_PTRACE, uintptr(request), uintptr(pid), addr, data, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ExecFiltered is the helper's side of EnterCommand: it installs the filters passed to
+// it and executes argv in its place.
+func ExecFiltered(argv []string) error {
+	if len(argv) == 0 {
+		return errors.New("no command")
+	}
+	f := os.NewFile(helperFiltersFD, "filters")
+	if f == nil {
+		return errors.New("no syscall filters passed")
+	}
+	var filters [][]syscall.SockFilter
+	for {
+		var n uint16
+		err := binary.Read(f, binary.NativeEndian, &n)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && n == 0 {
+			err = errors.New("empty filter")
+		}
+		prog := make([]syscall.SockFilter, n)
+		if err == nil {
+			err = binary.Read(f, binary.NativeEndian, prog)
+		}
+		if err != nil {
+			return fmt.Errorf("read syscall filters: %w", err)
+		}
+		filters = append(filters, prog)
+	}
+	f.Close()
+	syscall.CloseOnExec(helperExeFD)
+
+	// Filters apply to the thread that installs them and are inherited by what it
+	// executes, so both happen on this thread.
+	runtime.LockOSThread()
+	for _, prog := range filters {
+		fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&fprog))); errno != 0 {
+			return fmt.Errorf("install syscall filter: %w", errno)
+		}
+	}
+	return syscall.Exec(argv[0], argv, os.Environ())
+}
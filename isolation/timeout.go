@@ -0,0 +1,111 @@
+package isolation
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/cgroup"
+)
+
+var (
+	// ErrTimeout is returned when a session exceeds its wall-clock timeout.
+	ErrTimeout = errors.New("sandbox session timed out")
+	// ErrIdleTimeout is returned when a session produced no output and used no CPU for too long.
+	ErrIdleTimeout = errors.New("sandbox session was idle for too long")
+)
+
+// killGrace is how long the container gets to shut down after SIGTERM before it is killed.
+const killGrace = 10 * time.Second
+
+// activity records the last time a sandbox showed signs of life.
+type activity struct {
+	last atomic.Int64 // unix nanoseconds
+}
+
+func newActivity() *activity {
+	a := &activity{}
+	a.touch()
+	return a
+}
+
+func (a *activity) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+func (a *activity) idleFor() time.Duration {
+	return time.Since(time.Unix(0, a.last.Load()))
+}
+
+// activityWriter forwards writes to w and records them as activity.
+type activityWriter struct {
+	w io.Writer
+	a *activity
+}
+
+func (aw *activityWriter) Write(p []byte) (int, error) {
+	aw.a.touch()
+	return aw.w.Write(p)
+}
+
+// isTerminal reports whether f is a character device such as a tty.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// watchIdle cancels the session with ErrIdleTimeout once it has shown no output
+// and no CPU usage for the given duration. It returns when ctx is done.
+func watchIdle(ctx context.Context, cancel context.CancelCauseFunc, machine string, idle time.Duration, a *activity) {
+	poll := idle / 10
+	if poll < time.Second {
+		poll = time.Second
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	var lastCPU uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// CPU usage in the container's cgroup counts as activity, so quiet
+		// but busy builds are not killed.
+		if m, err := LookupMachine(machine); err == nil {
+			// A paused sandbox was stopped on purpose and is not idle.
+			if f, err := cgroup.Frozen(m.ProcessDir()); err == nil && f {
+				a.touch()
+				continue
+			}
+			if st, err := cgroup.ReadStats(cgroup.Path(m.ControlGroup)); err == nil {
+				if st.CPUUsageUsec != lastCPU {
+					lastCPU = st.CPUUsageUsec
+					a.touch()
+				}
+			}
+		}
+		if a.idleFor() >= idle {
+			log.Printf("Sandbox %s idle for %s, stopping it", machine, idle)
+			cancel(ErrIdleTimeout)
+			return
+		}
+	}
+}
+
+// terminateMachine makes sure no container processes survive a cancelled session.
+func terminateMachine(machine string) {
+	if _, err := LookupMachine(machine); err != nil {
+		return
+	}
+	log.Printf("Terminating leftover container %s", machine)
+	if err := exec.Command("machinectl", "terminate", machine).Run(); err != nil {
+		log.Printf("Warning: failed to terminate %s: %v", machine, err)
+	}
+}
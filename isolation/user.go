@@ -0,0 +1,58 @@
+package isolation
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var userNameRe = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+
+// IsUserName reports whether spec names a user rather than giving a UID[:GID].
+func IsUserName(spec string) bool {
+	return userNameRe.MatchString(spec)
+}
+
+// ValidateUser checks a user spec: a user name or UID[:GID].
+func ValidateUser(spec string) error {
+	if IsUserName(spec) {
+		return nil
+	}
+	_, _, err := ParseUser(spec)
+	return err
+}
+
+// ParseUser splits a UID[:GID] user spec; the GID defaults to the UID.
+func ParseUser(spec string) (uid, gid string, err error) {
+	uid, gid, found := strings.Cut(spec, ":")
+	if !found {
+		gid = uid
+	}
+	for _, id := range []string{uid, gid} {
+		if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+			return "", "", fmt.Errorf("invalid user %q: expected a user name or UID[:GID]", spec)
+		}
+	}
+	return uid, gid, nil
+}
+
+// setprivCommand wraps command to run as UID[:GID], which usually has no passwd entry
+// in the container.
+func setprivCommand(spec string, command []string) ([]string, error) {
+	uid, gid, err := ParseUser(spec)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := []string{"/usr/bin/setpriv", "--reuid=" + uid, "--regid=" + gid, "--clear-groups", "--"}
+	return append(wrapped, command...), nil
+}
+
+// shellQuote quotes args for use in a /bin/sh command line.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
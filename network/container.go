@@ -0,0 +1,48 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// containerInterface is the name systemd-nspawn gives the container end of the veth pair.
+const containerInterface = "host0"
+
+// WriteContainerConfig writes a systemd-networkd unit for the lease into the container's
+// root, so a sandbox booted with systemd configures itself the same way.
+func WriteContainerConfig(rootDir string, lease Lease) error {
+	dir := filepath.Join(rootDir, "etc", "systemd", "network")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	unit := fmt.Sprintf(`# Generated by arch-sandbox for network %s
+[Match]
+Name=%s
+
+[Network]
+Address=%s
+Gateway=%s
+`, lease.Network, containerInterface, lease.Address, lease.Gateway)
+	return os.WriteFile(filepath.Join(dir, "80-arch-sandbox.network"), []byte(unit), 0644)
+}
+
+// ConfigureContainer assigns the leased address and default route inside the network
+// namespace of the container whose init process is pid, using the host's ip binary.
+func ConfigureContainer(pid int, lease Lease) error {
+	target := strconv.Itoa(pid)
+	steps := [][]string{
+		{"ip", "link", "set", "lo", "up"},
+		{"ip", "addr", "replace", lease.Address.String(), "dev", containerInterface},
+		{"ip", "link", "set", containerInterface, "up"},
+		{"ip", "route", "replace", "default", "via", lease.Gateway.String()},
+	}
+	for _, step := range steps {
+		args := append([]string{"--target", target, "--net", "--"}, step...)
+		if err := run("nsenter", args...); err != nil {
+			return fmt.Errorf("configure container network: %w", err)
+		}
+	}
+	return nil
+}
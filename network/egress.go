@@ -0,0 +1,222 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Egress policy modes. An empty mode leaves outgoing traffic unrestricted.
+const (
+	EgressNone        = "none"
+	EgressAllow       = "allow"
+	EgressMirrorsOnly = "mirrors-only"
+)
+
+// EgressPolicy restricts the connections a sandbox may open to the outside.
+type EgressPolicy struct {
+	Mode string `yaml:"mode"`
+	// Allow lists destinations for the allow mode: a host name, IP address or CIDR,
+	// optionally followed by ":port", or ":port" alone for any destination.
+	Allow []string `yaml:"allow"`
+}
+
+// UnmarshalYAML also accepts a bare mode, e.g. "egress: none".
+func (p *EgressPolicy) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.Mode = value.Value
+		return nil
+	}
+	type plain EgressPolicy
+	return value.Decode((*plain)(p))
+}
+
+// Restricted reports whether the policy limits outgoing traffic at all.
+func (p EgressPolicy) Restricted() bool {
+	return p.Mode != ""
+}
+
+// Validate checks the mode and the syntax of the allow list without resolving names.
+func (p EgressPolicy) Validate() error {
+	switch p.Mode {
+	case "", EgressNone, EgressMirrorsOnly:
+		if len(p.Allow) > 0 && p.Mode != "" {
+			return fmt.Errorf("egress allow list is only used with mode %q", EgressAllow)
+		}
+	case EgressAllow:
+		if len(p.Allow) == 0 {
+			return fmt.Errorf("egress mode %q needs at least one allowed destination", EgressAllow)
+		}
+	default:
+		return fmt.Errorf("invalid egress mode %q: expected none, allow or mirrors-only", p.Mode)
+	}
+	for _, entry := range p.Allow {
+		if _, _, err := splitDestination(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MirrorDestinations returns "host:80" and "host:443" entries for every Server
+// configured in a pacman mirrorlist.
+func MirrorDestinations(mirrorlist string) ([]string, error) {
+	f, err := os.Open(mirrorlist)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seen := make(map[string]bool)
+	var dests []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.TrimSpace(key) != "Server" {
+			continue
+		}
+		// Server = https://mirror.example.org/archlinux/$repo/os/$arch
+		rest := strings.TrimSpace(value)
+		if i := strings.Index(rest, "://"); i >= 0 {
+			rest = rest[i+3:]
+		}
+		host, _, _ := strings.Cut(rest, "/")
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host != "" && !seen[host] {
+			seen[host] = true
+			dests = append(dests, host+":80", host+":443")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("no Server entries enabled in %s", mirrorlist)
+	}
+	return dests, nil
+}
+
+// compile turns the policy into the nftables rules of the sandbox's egress chain.
+// Traffic within the subnet and, unless the mode is none, DNS to the given resolvers
+// is always allowed. Host names are resolved now, so the rules reflect the addresses
+// at launch time. Mirrors-only policies must already be expanded into an allow list.
+func (p EgressPolicy) compile(sandbox, subnet string, resolvers []string) ([]string, error) {
+	rules := []string{
+		"ct state established,related accept",
+		"ip daddr " + subnet + " accept",
+	}
+	if p.Mode != EgressNone {
+		for _, r := range resolvers {
+			if addr, err := netip.ParseAddr(r); err == nil && addr.Is4() {
+				rules = append(rules, "ip daddr "+r+" udp dport 53 accept", "ip daddr "+r+" tcp dport 53 accept")
+			}
+		}
+		for _, entry := range p.Allow {
+			host, port, err := splitDestination(entry)
+			if err != nil {
+				return nil, err
+			}
+			var match []string
+			if host != "" {
+				addrs, err := resolveDestination(host)
+				if err != nil {
+					return nil, err
+				}
+				match = append(match, "ip daddr { "+strings.Join(addrs, ", ")+" }")
+			}
+			if port != "" {
+				for _, proto := range []string{"tcp", "udp"} {
+					rules = append(rules, strings.Join(append(append([]string{}, match...), proto+" dport "+port), " ")+" accept")
+				}
+			} else {
+				rules = append(rules, strings.Join(match, " ")+" accept")
+			}
+		}
+	}
+	rules = append(rules,
+		fmt.Sprintf(`limit rate 10/second log prefix "arch-sandbox egress denied %s: " level warn`, logSafe(sandbox)),
+		"drop",
+	)
+	return rules, nil
+}
+
+// splitDestination splits "host:port", "cidr:port", ":port" or a bare host/CIDR.
+func splitDestination(entry string) (host, port string, err error) {
+	host = entry
+	if i := strings.LastIndex(entry, ":"); i >= 0 {
+		host, port = entry[:i], entry[i+1:]
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", "", fmt.Errorf("invalid egress destination %q: bad port %q", entry, port)
+		}
+	}
+	if host == "" && port == "" {
+		return "", "", fmt.Errorf("invalid egress destination %q", entry)
+	}
+	return host, port, nil
+}
+
+// resolveDestination returns the IPv4 addresses or CIDR for a destination host.
+func resolveDestination(host string) ([]string, error) {
+	if prefix, err := netip.ParsePrefix(host); err == nil {
+		return []string{prefix.Masked().String()}, nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []string{addr.String()}, nil
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve egress destination %s: %w", host, err)
+	}
+	var addrs []string
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			addrs = append(addrs, v4.String())
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("egress destination %s has no IPv4 address", host)
+	}
+	return addrs, nil
+}
+
+var unsafeLogChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// logSafe strips characters that cannot appear in an nftables log prefix.
+func logSafe(s string) string {
+	return unsafeLogChars.ReplaceAllString(s, "_")
+}
+
+// HostResolvers returns the IPv4 name servers the host uses, preferring the upstream
+// servers of systemd-resolved over its local stub.
+func HostResolvers() []string {
+	for _, path := range []string{"/run/systemd/resolve/resolv.conf", "/etc/resolv.conf"} {
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		var servers []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 2 && fields[0] == "nameserver" {
+				if addr, err := netip.ParseAddr(fields[1]); err == nil && addr.Is4() && !addr.IsLoopback() {
+					servers = append(servers, fields[1])
+				}
+			}
+		}
+		f.Close()
+		if len(servers) > 0 {
+			return servers
+		}
+	}
+	return nil
+}
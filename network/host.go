@@ -0,0 +1,229 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// AttachOptions controls how a sandbox joins a network.
+type AttachOptions struct {
+	Subnet    string       // subnet of the default network when it is first created
+	Egress    EgressPolicy // outgoing traffic policy; mirrors-only must be expanded to an allow list
+	Resolvers []string     // DNS servers the sandbox may query under a restrictive egress policy
+}
+
+// Attach leases an address for sandbox on the named network and makes sure the host
+// bridge, NAT and egress rules are up. The default network is created with the given
+// subnet on first use; other networks must have been created with Create.
+func (s *Store) Attach(name, sandbox string, opts AttachOptions) (Lease, error) {
+	for _, tool := range []string{"ip", "nft"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return Lease{}, fmt.Errorf("private networking needs %s: %w", tool, err)
+		}
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return Lease{}, err
+	}
+	defer unlock()
+
+	var n *Network
+	if name == DefaultNetwork {
+		n, err = s.define(name, opts.Subnet)
+	} else {
+		n, err = s.Get(name)
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+	lease, err := n.allocate(sandbox)
+	if err != nil {
+		return Lease{}, err
+	}
+	delete(n.Egress, sandbox)
+	if opts.Egress.Restricted() {
+		rules, err := opts.Egress.compile(sandbox, n.Subnet, opts.Resolvers)
+		if err != nil {
+			delete(n.Leases, sandbox)
+			return Lease{}, err
+		}
+		if n.Egress == nil {
+			n.Egress = make(map[string][]string)
+		}
+		n.Egress[sandbox] = rules
+	}
+	if err := setupHost(n, lease.Gateway); err != nil {
+		return Lease{}, err
+	}
+	if err := s.save(n); err != nil {
+		return Lease{}, err
+	}
+	if err := s.syncHosts(n); err != nil {
+		return Lease{}, fmt.Errorf("update hosts files of network %s: %w", n.Name, err)
+	}
+	log.Printf("Sandbox %s attached to network %s with address %s", sandbox, n.Name, lease.Address)
+	return lease, nil
+}
+
+// Release returns every address leased to sandbox. Networks left without members
+// have their bridge and NAT rules removed from the host.
+func (s *Store) Release(sandbox string) error {
+	if _, err := os.Stat(s.Dir); os.IsNotExist(err) {
+		return nil
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	networks, err := s.list()
+	if err != nil {
+		return err
+	}
+	for _, n := range networks {
+		if _, ok := n.Leases[sandbox]; !ok {
+			continue
+		}
+		delete(n.Leases, sandbox)
+		delete(n.Egress, sandbox)
+		if err := s.save(n); err != nil {
+			return err
+		}
+		log.Printf("Released address of sandbox %s on network %s", sandbox, n.Name)
+		if len(n.Leases) == 0 {
+			teardownHost(n)
+		} else if err := applyRuleset(n); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		if err := s.syncHosts(n); err != nil {
+			log.Printf("Warning: failed to update hosts files of network %s: %v", n.Name, err)
+		}
+	}
+	return nil
+}
+
+// setupHost creates the bridge with the gateway address and installs the NAT rules.
+// It is idempotent so it can run for every sandbox that joins.
+func setupHost(n *Network, gateway netip.Addr) error {
+	prefix, _ := netip.ParsePrefix(n.Subnet)
+	gw := netip.PrefixFrom(gateway, prefix.Bits()).String()
+
+	if err := exec.Command("ip", "link", "show", n.Bridge).Run(); err != nil {
+		if err := run("ip", "link", "add", "name", n.Bridge, "type", "bridge"); err != nil {
+			return err
+		}
+	}
+	if out, _ := exec.Command("ip", "-o", "addr", "show", "dev", n.Bridge).Output(); !strings.Contains(string(out), " "+gw+" ") {
+		if err := run("ip", "addr", "add", gw, "dev", n.Bridge); err != nil {
+			return err
+		}
+	}
+	if err := run("ip", "link", "set", n.Bridge, "up"); err != nil {
+		return err
+	}
+	if err := os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0644); err != nil {
+		return fmt.Errorf("enable IPv4 forwarding: %w", err)
+	}
+	return applyRuleset(n)
+}
+
+// teardownHost removes the bridge and NAT rules of a network. Failures are only logged
+// because the sandbox itself is already gone.
+func teardownHost(n *Network) {
+	if err := exec.Command("ip", "link", "show", n.Bridge).Run(); err != nil {
+		return
+	}
+	log.Printf("Removing bridge %s of network %s", n.Bridge, n.Name)
+	if err := run("nft", "delete", "table", "inet", tableName(n)); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	if err := run("ip", "link", "delete", n.Bridge); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// tableName is the nftables table holding a network's rules.
+func tableName(n *Network) string {
+	return "arch_sandbox_" + strings.ReplaceAll(n.Name, "-", "_")
+}
+
+// applyRuleset (re)creates the network's nftables table from the stored state.
+func applyRuleset(n *Network) error {
+	cmd := exec.Command("nft", "-f", "-")
+	cmd.Stdin = strings.NewReader(renderRuleset(n))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("install nftables rules for network %s: %v: %s", n.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// renderRuleset builds the network's nftables table: masquerade traffic leaving the
+// subnet, send members with an egress policy through their own chain, drop traffic
+// from unleased addresses and IPv6 if there are such members, and accept other
+// forwarding to and from the bridge.
+func renderRuleset(n *Network) string {
+	table := tableName(n)
+	var chains, jumps strings.Builder
+	var leases []string
+	for _, addr := range n.Leases {
+		leases = append(leases, addr)
+	}
+	sort.Strings(leases)
+	members := make([]string, 0, len(n.Egress))
+	for member := range n.Egress {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	for _, member := range members {
+		addr, ok := n.Leases[member]
+		if !ok {
+			continue
+		}
+		chain := "egress_" + strings.ReplaceAll(addr, ".", "_")
+		fmt.Fprintf(&chains, "\tchain %s {\n", chain)
+		for _, rule := range n.Egress[member] {
+			fmt.Fprintf(&chains, "\t\t%s\n", rule)
+		}
+		chains.WriteString("\t}\n")
+		fmt.Fprintf(&jumps, "\t\tiifname \"%s\" ip saddr %s jump %s\n", n.Bridge, addr, chain)
+	}
+	if jumps.Len() > 0 {
+		// A sandbox that changed its address would otherwise skip its chain, and the
+		// networks are IPv4 only, so IPv6 would not go through the chains at all.
+		fmt.Fprintf(&jumps, "\t\tiifname \"%s\" ip saddr != { %s } drop\n", n.Bridge, strings.Join(leases, ", "))
+		fmt.Fprintf(&jumps, "\t\tiifname \"%s\" meta nfproto ipv6 drop\n", n.Bridge)
+	}
+
+	return fmt.Sprintf(`table inet %[1]s
+delete table inet %[1]s
+table inet %[1]s {
+%[4]s	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		ip saddr %[2]s ip daddr != %[2]s masquerade
+	}
+	chain input {
+		type filter hook input priority filter; policy accept;
+%[5]s	}
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+%[5]s		iifname "%[3]s" accept
+		oifname "%[3]s" ct state established,related accept
+	}
+}
+`, table, n.Subnet, n.Bridge, chains.String(), jumps.String())
+}
+
+// run executes a command and includes its output in the error.
+func run(name string, args ...string) error {
+	if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// defaultHosts is used when a sandbox has no /etc/hosts yet.
+const defaultHosts = "127.0.0.1 localhost\n::1 localhost\n"
+
+// syncHosts rewrites the arch-sandbox block for network n in the hosts file of every
+// member, so members can reach each other by sandbox name.
+func (s *Store) syncHosts(n *Network) error {
+	if s.HostsFile == nil {
+		return nil
+	}
+	for member := range n.Leases {
+		path := s.HostsFile(member)
+		if path == "" {
+			continue
+		}
+		if err := updateHostsFile(path, n.Name, n.Leases); err != nil {
+			return fmt.Errorf("sandbox %s: %w", member, err)
+		}
+	}
+	return nil
+}
+
+// updateHostsFile replaces the block for network in the hosts file at path with entries.
+// Lines outside the block are left untouched.
+func updateHostsFile(path, network string, entries map[string]string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = []byte(defaultHosts), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	begin := "# BEGIN arch-sandbox network " + network
+	end := "# END arch-sandbox network " + network
+	var lines []string
+	inBlock := false
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		switch {
+		case line == begin:
+			inBlock = true
+		case line == end:
+			inBlock = false
+		case !inBlock:
+			lines = append(lines, line)
+		}
+	}
+
+	if len(entries) > 0 {
+		names := make([]string, 0, len(entries))
+		for name := range entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines = append(lines, begin)
+		for _, name := range names {
+			lines = append(lines, entries[name]+" "+name+" "+name+"."+network)
+		}
+		lines = append(lines, end)
+	}
+	return replaceFile(path, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// replaceFile atomically replaces the file at path with data, keeping its mode and
+// owner, so programs in a running sandbox never read a partly written hosts file.
+func replaceFile(path string, data []byte) error {
+	mode, uid, gid := os.FileMode(0644), -1, -1
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(st.Uid), int(st.Gid)
+		}
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".hosts-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if err == nil && uid >= 0 {
+		err = f.Chown(uid, gid)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,301 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultNetwork is the network sandboxes join in "private" network mode.
+const DefaultNetwork = "default"
+
+// DefaultSubnet is the address range of the default network.
+const DefaultSubnet = "10.89.0.0/24"
+
+// Network is a host bridge shared by sandboxes, with the addresses leased on it.
+type Network struct {
+	Name   string            `yaml:"name"`
+	Bridge string            `yaml:"bridge"`
+	Subnet string            `yaml:"subnet"`
+	Leases map[string]string `yaml:"leases"` // sandbox name -> address
+	// Egress holds the compiled egress chain of members with a restrictive policy.
+	Egress map[string][]string `yaml:"egress,omitempty"`
+}
+
+// Lease is an address assigned to a sandbox on a network.
+type Lease struct {
+	Network string
+	Bridge  string
+	Address netip.Prefix // the sandbox's address with the subnet's prefix length
+	Gateway netip.Addr
+}
+
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Store keeps network definitions and address leases under <base-dir>/networks.
+type Store struct {
+	Dir string
+	// HostsFile, if set, returns the host path of a member sandbox's /etc/hosts,
+	// or "" when the sandbox's filesystem is not available.
+	HostsFile func(sandbox string) string
+}
+
+// NewStore returns the network store for a sandbox base directory.
+func NewStore(baseDir string) *Store {
+	return &Store{Dir: filepath.Join(baseDir, "networks")}
+}
+
+// lock takes an exclusive lock on the store so concurrent sandboxes don't hand out the same address.
+func (s *Store) lock() (func(), error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.Dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock network store: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.Dir, name+".yaml")
+}
+
+// load reads a network definition; it returns an error wrapping os.ErrNotExist if there is none.
+func (s *Store) load(name string) (*Network, error) {
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	var n Network
+	if err := yaml.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("parse network %q: %w", name, err)
+	}
+	if n.Leases == nil {
+		n.Leases = make(map[string]string)
+	}
+	return &n, nil
+}
+
+func (s *Store) save(n *Network) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path(n.Name), data, 0644)
+}
+
+// list returns every network in the store, sorted by name.
+func (s *Store) list() ([]*Network, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var networks []*Network
+	for _, m := range matches {
+		name := filepath.Base(m)
+		n, err := s.load(name[:len(name)-len(".yaml")])
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, n)
+	}
+	sort.Slice(networks, func(i, j int) bool { return networks[i].Name < networks[j].Name })
+	return networks, nil
+}
+
+// builtinModes are network modes that cannot be used as network names.
+var builtinModes = map[string]bool{"host": true, "private": true, "none": true}
+
+// ValidName reports whether name can be used for a network.
+func ValidName(name string) bool {
+	return nameRe.MatchString(name) && !builtinModes[name]
+}
+
+// Create defines a new named network. An empty subnet picks the next free /24 after DefaultSubnet.
+func (s *Store) Create(name, subnet string) (*Network, error) {
+	if !ValidName(name) {
+		return nil, fmt.Errorf("invalid network name %q: use lowercase letters, digits, '-' and '_' (host, private and none are reserved)", name)
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if _, err := s.load(name); err == nil {
+		return nil, fmt.Errorf("network %q already exists", name)
+	}
+	if subnet == "" {
+		if subnet, err = s.freeSubnet(); err != nil {
+			return nil, err
+		}
+	}
+	return s.define(name, subnet)
+}
+
+// List returns every defined network, sorted by name.
+func (s *Store) List() ([]*Network, error) {
+	if _, err := os.Stat(s.Dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.list()
+}
+
+// Get returns the named network.
+func (s *Store) Get(name string) (*Network, error) {
+	n, err := s.load(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("network %q does not exist (create it with 'arch-sandbox network create %s')", name, name)
+	}
+	return n, err
+}
+
+// Remove deletes a network definition. It fails while sandboxes are attached.
+func (s *Store) Remove(name string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	n, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	if len(n.Leases) > 0 {
+		members := make([]string, 0, len(n.Leases))
+		for m := range n.Leases {
+			members = append(members, m)
+		}
+		sort.Strings(members)
+		return fmt.Errorf("network %q is in use by: %s", name, strings.Join(members, ", "))
+	}
+	teardownHost(n)
+	return os.Remove(s.path(name))
+}
+
+// freeSubnet returns the first /24 in 10.89.0.0/16 that no network uses yet,
+// leaving DefaultSubnet for the default network.
+func (s *Store) freeSubnet() (string, error) {
+	existing, err := s.list()
+	if err != nil {
+		return "", err
+	}
+	base := netip.MustParsePrefix(DefaultSubnet).Addr().As4()
+	for i := 1; i < 256; i++ {
+		candidate := netip.PrefixFrom(netip.AddrFrom4([4]byte{base[0], base[1], byte(i), 0}), 24)
+		free := true
+		for _, n := range existing {
+			if p, err := netip.ParsePrefix(n.Subnet); err == nil && p.Overlaps(candidate) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return candidate.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no free subnet left in 10.89.0.0/16, pass one explicitly")
+}
+
+// define creates a network definition with a free bridge name, or returns the existing one.
+func (s *Store) define(name, subnet string) (*Network, error) {
+	if !nameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid network name %q: use lowercase letters, digits, '-' and '_'", name)
+	}
+	n, err := s.load(name)
+	if err == nil {
+		if subnet != "" && subnet != n.Subnet {
+			return nil, fmt.Errorf("network %q already uses subnet %s", name, n.Subnet)
+		}
+		return n, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	existing, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	if subnet == "" {
+		subnet = DefaultSubnet
+	}
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil || !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return nil, fmt.Errorf("invalid subnet %q: expected an IPv4 CIDR of at most /30", subnet)
+	}
+	prefix = prefix.Masked()
+	used := make(map[string]bool)
+	for _, other := range existing {
+		used[other.Bridge] = true
+		if op, err := netip.ParsePrefix(other.Subnet); err == nil && op.Overlaps(prefix) {
+			return nil, fmt.Errorf("subnet %s overlaps network %q (%s)", prefix, other.Name, other.Subnet)
+		}
+	}
+	bridge := ""
+	for i := 0; bridge == ""; i++ {
+		if candidate := fmt.Sprintf("asbr%d", i); !used[candidate] {
+			bridge = candidate
+		}
+	}
+	n = &Network{Name: name, Bridge: bridge, Subnet: prefix.String(), Leases: make(map[string]string)}
+	return n, s.save(n)
+}
+
+// allocate leases the lowest free address in the network to sandbox, reusing an existing lease.
+func (n *Network) allocate(sandbox string) (Lease, error) {
+	prefix, err := netip.ParsePrefix(n.Subnet)
+	if err != nil {
+		return Lease{}, fmt.Errorf("network %q: %w", n.Name, err)
+	}
+	gateway := prefix.Addr().Next()
+	lease := Lease{Network: n.Name, Bridge: n.Bridge, Gateway: gateway}
+
+	if addr, ok := n.Leases[sandbox]; ok {
+		a, err := netip.ParseAddr(addr)
+		if err != nil {
+			return Lease{}, fmt.Errorf("network %q: bad lease for %s: %w", n.Name, sandbox, err)
+		}
+		lease.Address = netip.PrefixFrom(a, prefix.Bits())
+		return lease, nil
+	}
+
+	taken := make(map[string]bool)
+	for _, addr := range n.Leases {
+		taken[addr] = true
+	}
+	for a := gateway.Next(); prefix.Contains(a.Next()); a = a.Next() {
+		// prefix.Contains(a.Next()) stops before the broadcast address.
+		if !taken[a.String()] {
+			n.Leases[sandbox] = a.String()
+			lease.Address = netip.PrefixFrom(a, prefix.Bits())
+			return lease, nil
+		}
+	}
+	return Lease{}, fmt.Errorf("network %q has no free addresses in %s", n.Name, n.Subnet)
+}
@@ -0,0 +1,152 @@
+package pkgcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Dir returns the shared pacman package cache of a sandbox base directory.
+func Dir(baseDir string) string {
+	return filepath.Join(baseDir, "cache", "pacman")
+}
+
+// ErrInUse is returned by LockExclusive while sandboxes are using the cache.
+var ErrInUse = errors.New("package cache is in use by running sandboxes")
+
+// Lock is a held lock on the cache.
+type Lock struct {
+	f *os.File
+}
+
+// LockShared takes a shared lock on the cache, held by every sandbox using it.
+func LockShared(dir string) (*Lock, error) {
+	return lock(dir, syscall.LOCK_SH)
+}
+
+// LockExclusive takes the lock needed to remove packages. It fails with ErrInUse
+// instead of waiting while sandboxes hold shared locks.
+func LockExclusive(dir string) (*Lock, error) {
+	l, err := lock(dir, syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return nil, ErrInUse
+	}
+	return l, err
+}
+
+func lock(dir string, how int) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// Package is a package file in the cache.
+type Package struct {
+	Name    string
+	Version string // pkgver-pkgrel, with the epoch if any
+	Arch    string
+	File    string
+	Size    int64 // including the signature file
+}
+
+// parsePackageFile splits name-pkgver-pkgrel-arch.pkg.tar.* into its parts.
+func parsePackageFile(file string) (name, version, arch string, ok bool) {
+	i := strings.Index(file, ".pkg.tar")
+	if i < 0 || strings.HasSuffix(file, ".sig") || strings.HasSuffix(file, ".part") {
+		return "", "", "", false
+	}
+	parts := strings.Split(file[:i], "-")
+	if len(parts) < 4 {
+		return "", "", "", false
+	}
+	n := len(parts)
+	return strings.Join(parts[:n-3], "-"), parts[n-3] + "-" + parts[n-2], parts[n-1], true
+}
+
+// List returns the packages in the cache, grouped by name and architecture with the
+// newest version first.
+func List(dir string) ([]Package, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var pkgs []Package
+	for _, entry := range entries {
+		name, version, arch, ok := parsePackageFile(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		p := Package{Name: name, Version: version, Arch: arch, File: filepath.Join(dir, entry.Name())}
+		if info, err := entry.Info(); err == nil {
+			p.Size = info.Size()
+		}
+		if info, err := os.Stat(p.File + ".sig"); err == nil {
+			p.Size += info.Size()
+		}
+		pkgs = append(pkgs, p)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		if pkgs[i].Arch != pkgs[j].Arch {
+			return pkgs[i].Arch < pkgs[j].Arch
+		}
+		return Vercmp(pkgs[i].Version, pkgs[j].Version) > 0
+	})
+	return pkgs, nil
+}
+
+// Stale returns the packages that are not among the keep newest versions of their
+// name and architecture, like paccache -rk.
+func Stale(pkgs []Package, keep int) []Package {
+	var stale []Package
+	seen := 0
+	for i, p := range pkgs {
+		if i == 0 || p.Name != pkgs[i-1].Name || p.Arch != pkgs[i-1].Arch {
+			seen = 0
+		}
+		seen++
+		if seen > keep {
+			stale = append(stale, p)
+		}
+	}
+	return stale
+}
+
+// Remove deletes package files and their signatures.
+func Remove(pkgs []Package) error {
+	for _, p := range pkgs {
+		if err := os.Remove(p.File); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %w", p.File, err)
+		}
+		if err := os.Remove(p.File + ".sig"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s.sig: %w", p.File, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package pkgcache
+
+import "strings"
+
+// Vercmp compares two pacman package versions of the form [epoch:]pkgver[-pkgrel] the way
+// vercmp(8) does, returning -1, 0 or 1.
+func Vercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	epochA, verA, relA := splitVersion(a)
+	epochB, verB, relB := splitVersion(b)
+	if r := rpmvercmp(epochA, epochB); r != 0 {
+		return r
+	}
+	if r := rpmvercmp(verA, verB); r != 0 {
+		return r
+	}
+	if relA != "" && relB != "" {
+		return rpmvercmp(relA, relB)
+	}
+	return 0
+}
+
+// splitVersion splits [epoch:]pkgver[-pkgrel]; the epoch defaults to 0.
+func splitVersion(v string) (epoch, ver, rel string) {
+	epoch = "0"
+	if i := strings.IndexFunc(v, func(r rune) bool { return r < '0' || r > '9' }); i > 0 && v[i] == ':' {
+		epoch, v = v[:i], v[i+1:]
+	} else if i == 0 && v[0] == ':' {
+		v = v[1:]
+	}
+	if i := strings.LastIndexByte(v, '-'); i >= 0 {
+		v, rel = v[:i], v[i+1:]
+	}
+	return epoch, v, rel
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isAlnum(c byte) bool { return isDigit(c) || isAlpha(c) }
+
+// rpmvercmp compares version segments as libalpm does: numeric segments numerically,
+// alphabetic ones lexically, and a numeric segment is newer than an alphabetic one.
+func rpmvercmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	one, two := 0, 0     // start of the current segment
+	prev1, prev2 := 0, 0 // end of the previous segment
+	for one < len(a) && two < len(b) {
+		for one < len(a) && !isAlnum(a[one]) {
+			one++
+		}
+		for two < len(b) && !isAlnum(b[two]) {
+			two++
+		}
+		if one >= len(a) || two >= len(b) {
+			break
+		}
+		// Different separator lengths decide, e.g. 1.0 < 1..0.
+		if one-prev1 != two-prev2 {
+			if one-prev1 < two-prev2 {
+				return -1
+			}
+			return 1
+		}
+
+		end1, end2 := one, two
+		isNum := isDigit(a[one])
+		class := isAlpha
+		if isNum {
+			class = isDigit
+		}
+		for end1 < len(a) && class(a[end1]) {
+			end1++
+		}
+		for end2 < len(b) && class(b[end2]) {
+			end2++
+		}
+		if end2 == two {
+			// Segments of different types: numeric is newer.
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		seg1, seg2 := a[one:end1], b[two:end2]
+		if isNum {
+			seg1, seg2 = strings.TrimLeft(seg1, "0"), strings.TrimLeft(seg2, "0")
+			if len(seg1) != len(seg2) {
+				if len(seg1) > len(seg2) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(seg1, seg2); c != 0 {
+			return c
+		}
+		one, two = end1, end2
+		prev1, prev2 = end1, end2
+	}
+
+	if one >= len(a) && two >= len(b) {
+		return 0
+	}
+	// A remaining alphabetic part never beats an empty one, e.g. 1.0 > 1.0alpha.
+	if (one >= len(a) && !isAlpha(b[two])) || (one < len(a) && isAlpha(a[one])) {
+		return -1
+	}
+	return 1
+}
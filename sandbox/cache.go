@@ -0,0 +1,85 @@
+package sandbox
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/pkgcache"
+)
+
+// packageCacheTarget is pacman's default CacheDir inside the sandbox.
+const packageCacheTarget = "/var/cache/pacman/pkg"
+
+// PackageCacheDir returns the pacman package cache shared by all sandboxes in baseDir.
+func PackageCacheDir(baseDir string) string {
+	return pkgcache.Dir(baseDir)
+}
+
+func (s *Sandbox) packageCacheDir() string {
+	return PackageCacheDir(filepath.Dir(s.BaseDir))
+}
+
+// usesPackageCache reports whether a sandbox with this configuration shares the package
+// cache. With private users, root in the sandbox maps to an unprivileged host UID that
+// cannot write the cache, so those sandboxes keep their own. An explicit mount of the
+// cache directory takes precedence as well.
+func (c SandboxConfig) usesPackageCache(profile isolation.Profile) bool {
+	if c.NoSharedCache {
+		return false
+	}
+	for _, m := range c.Mounts {
+		if filepath.Clean(m.Target) == packageCacheTarget {
+			return false
+		}
+	}
+	if profile.PrivateUsers {
+		log.Printf("Not sharing the package cache: profile %s uses private users", profile.Name)
+		return false
+	}
+	return true
+}
+
+// packageCacheMount takes a shared lock on the package cache for the lifetime of the
+// sandbox, so cache clean never removes packages while pacman may be reading them, and
+// returns the mount of the cache into the sandbox. The lock is released by Cleanup.
+func (s *Sandbox) packageCacheMount() (isolation.Mount, error) {
+	dir := s.packageCacheDir()
+	lock, err := pkgcache.LockShared(dir)
+	if err != nil {
+		return isolation.Mount{}, err
+	}
+	s.cacheLock = lock
+	return isolation.Mount{Type: isolation.MountBind, Source: dir, Target: packageCacheTarget}, nil
+}
+
+// MountPackageCache bind-mounts the shared package cache into the sandbox's mounted root
+// filesystem for pacman runs through arch-chroot, unless the configuration opts out.
+// The returned function unmounts it again.
+func (s *Sandbox) MountPackageCache(cfg SandboxConfig) (func(), error) {
+	if cfg.NoSharedCache {
+		return func() {}, nil
+	}
+	dir := s.packageCacheDir()
+	lock, err := pkgcache.LockShared(dir)
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.Join(s.OverlayDir, packageCacheTarget)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	if err := filesystem.BindMount(dir, target); err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+	return func() {
+		if err := filesystem.Unmount(target, filesystem.UnmountOptions{}); err != nil {
+			log.Printf("Warning: failed to unmount package cache: %v", err)
+		}
+		lock.Unlock()
+	}, nil
+}
@@ -0,0 +1,343 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/isolation"
+)
+
+// CopyTarget is one side of a copy: a path on the host, a path in a sandbox, or "-"
+// for a tar stream on stdin or stdout.
+type CopyTarget struct {
+	Sandbox *Sandbox // nil for the host
+	Path    string
+
+	machine  *isolation.Machine // set while copying from or to a running sandbox
+	releases []func()           // close what commands run in the running sandbox inherited
+	mounted  bool               // the stopped sandbox's filesystem was mounted for the copy
+	unlock   func()             // releases the stopped sandbox's lock
+	staging  string             // where files for a stopped sandbox are extracted first
+	dest     string             // resolved directory the staged files are moved to
+}
+
+// ParseCopyTarget parses a cp argument: <name>:<path> for a path in a sandbox in
+// baseDir, "-" for a tar stream, or a host path.
+func ParseCopyTarget(baseDir, arg string) (CopyTarget, error) {
+	if arg == "-" {
+		return CopyTarget{Path: "-"}, nil
+	}
+	name, p, found := strings.Cut(arg, ":")
+	if found && name != "" && !strings.ContainsRune(name, '/') {
+		sb, err := NewSandboxWithBaseDir(name, true, baseDir)
+		if err == nil && dirExists(sb.BaseDir) {
+			if !path.IsAbs(p) {
+				return CopyTarget{}, fmt.Errorf("path %q in sandbox %s must be absolute", p, name)
+			}
+			return CopyTarget{Sandbox: sb, Path: path.Clean(p)}, nil
+		}
+	}
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return CopyTarget{}, err
+	}
+	return CopyTarget{Path: abs}, nil
+}
+
+func (t CopyTarget) String() string {
+	if t.Sandbox != nil {
+		return t.Sandbox.Name + ":" + t.Path
+	}
+	return t.Path
+}
+
+// Copy copies src to dst as a tar stream, preserving modes, numeric ownership and
+// timestamps. If dst is an existing directory, src is copied into it; otherwise it is
+// copied to dst under that name. Running sandboxes are accessed through their
+// namespaces, stopped ones by mounting their filesystem and using the host's tar.
+func Copy(src, dst CopyTarget) error {
+	if src.Path == "-" && dst.Path == "-" {
+		return errors.New("cannot copy from a stream to a stream")
+	}
+	if src.Sandbox == nil && dst.Sandbox == nil {
+		return errors.New("one side of the copy must be in a sandbox (<name>:<path>)")
+	}
+	for _, t := range []*CopyTarget{&src, &dst} {
+		defer t.close()
+		if err := t.open(t == &dst); err != nil {
+			return err
+		}
+	}
+
+	var reader, writer *exec.Cmd
+	if src.Path != "-" {
+		args, err := src.createArgs()
+		if err != nil {
+			return err
+		}
+		if reader, err = src.command("tar", args...); err != nil {
+			return err
+		}
+	}
+	if dst.Path != "-" {
+		args, err := dst.extractArgs(src)
+		if err != nil {
+			return err
+		}
+		if writer, err = dst.command("tar", args...); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case reader == nil:
+		writer.Stdin = os.Stdin
+		if err := run(writer); err != nil {
+			return err
+		}
+		return dst.commit()
+	case writer == nil:
+		reader.Stdout = os.Stdout
+		return run(reader)
+	}
+	pipe, err := reader.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	writer.Stdin = pipe
+	if err := reader.Start(); err != nil {
+		return err
+	}
+	writeErr := run(writer)
+	readErr := reader.Wait()
+	if readErr != nil {
+		return fmt.Errorf("read %s: %w", src, readErr)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write %s: %w", dst, writeErr)
+	}
+	return dst.commit()
+}
+
+func run(cmd *exec.Cmd) error {
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// open attaches to a running sandbox or locks and mounts the filesystem of a stopped
+// one, unless it is mounted on the host in a way that does not allow the copy.
+func (t *CopyTarget) open(write bool) error {
+	if t.Sandbox == nil {
+		return nil
+	}
+	m, err := t.Sandbox.Machine()
+	if err == nil {
+		t.machine = m
+		return nil
+	}
+	if !errors.Is(err, isolation.ErrNotRunning) {
+		return err
+	}
+	// Keep the sandbox from being started, or used by run, with its filesystem mounted here.
+	if t.unlock, err = t.Sandbox.Lock(); err != nil {
+		return err
+	}
+	if m, err := t.Sandbox.Machine(); err == nil {
+		t.machine = m
+		return nil
+	}
+	hm, err := t.Sandbox.HostMount()
+	if err != nil {
+		return err
+	}
+	switch {
+	case hm != nil && hm.Snapshot != "":
+		return fmt.Errorf("sandbox '%s' is mounted on the host at snapshot '%s'; unmount it first", t.Sandbox.Name, hm.Snapshot)
+	case hm != nil && hm.ReadOnly && write:
+		return fmt.Errorf("sandbox '%s' is mounted read-only on the host; unmount it first", t.Sandbox.Name)
+	}
+	if mounted, _ := filesystem.IsMounted(t.Sandbox.OverlayDir); mounted {
+		return nil
+	}
+	if err := t.Sandbox.Mount(); err != nil {
+		return err
+	}
+	t.mounted = true
+	return nil
+}
+
+// close removes what is left of the staging dir and undoes open.
+func (t *CopyTarget) close() {
+	if t.staging != "" {
+		os.RemoveAll(t.staging)
+	}
+	if t.mounted {
+		t.Sandbox.Unmount()
+	}
+	if t.unlock != nil {
+		t.unlock()
+	}
+	for _, release := range t.releases {
+		release()
+	}
+}
+
+// command runs name where the target's files are: in the running sandbox's
+// namespaces with no more privileges than the sandbox itself, or on the host. A
+// stopped sandbox's own binaries are never run on the host; its paths are passed
+// through hostPath instead.
+func (t *CopyTarget) command(name string, args ...string) (*exec.Cmd, error) {
+	if t.machine != nil {
+		cmd, release, err := isolation.EnterCommand(t.machine, "/", append([]string{name}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		t.releases = append(t.releases, release)
+		return cmd, nil
+	}
+	return exec.Command(name, args...), nil
+}
+
+// hostPath returns where p is on the host. For a stopped sandbox, symlinks are resolved
+// within its filesystem, so they never point at host files.
+func (t *CopyTarget) hostPath(p string) (string, error) {
+	if t.Sandbox == nil {
+		return p, nil
+	}
+	return filesystem.ResolveIn(t.Sandbox.OverlayDir, p)
+}
+
+// isDir reports whether the target's path is an existing directory.
+func (t *CopyTarget) isDir() bool {
+	if t.machine != nil {
+		cmd, err := t.command("test", "-d", t.Path)
+		return err == nil && cmd.Run() == nil
+	}
+	p, err := t.hostPath(t.Path)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// createArgs returns the tar arguments that archive the source path. Secrets are never
+// copied out of a sandbox.
+func (t *CopyTarget) createArgs() ([]string, error) {
+	dir, base := path.Split(t.Path)
+	if base == "" {
+		dir, base = "/", "."
+	}
+	if t.machine == nil {
+		var err error
+		if dir, err = t.hostPath(dir); err != nil {
+			return nil, err
+		}
+	}
+	args := []string{"--numeric-owner", "-C", dir, "-cf", "-"}
+	if t.Sandbox != nil {
+		if t.Path == SecretsDir || strings.HasPrefix(t.Path, SecretsDir+"/") {
+			return nil, fmt.Errorf("secrets in %s cannot be copied out of a sandbox", SecretsDir)
+		}
+		if rel, err := filepath.Rel(t.Path, SecretsDir); err == nil && !strings.HasPrefix(rel, "..") {
+			args = append(args, "--anchored", "--exclude="+path.Join(base, rel))
+		}
+	}
+	return append(args, base), nil
+}
+
+// extractArgs returns the tar arguments that unpack src at the target's path.
+func (t *CopyTarget) extractArgs(src CopyTarget) ([]string, error) {
+	args := []string{"--numeric-owner", "--same-owner", "--same-permissions", "-xf", "-"}
+	dir, rename := t.Path, []string{}
+	if src.Path != "-" && !t.isDir() {
+		// Copy to a new name: unpack next to it and rename the top-level entry.
+		var base string
+		dir, base = path.Split(t.Path)
+		srcBase := path.Base(src.Path)
+		rename = []string{"--transform", "s|^" + patternEscaper.Replace(srcBase) + "|" + replacementEscaper.Replace(base) + "|"}
+	}
+	if t.machine == nil && t.Sandbox != nil {
+		// The host's tar would follow symlinks the sandbox placed in dir out to the host.
+		// Unpack into a new directory instead and move the files over with commit.
+		dest, err := t.hostPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		staging, err := os.MkdirTemp(t.Sandbox.OverlayDir, ".arch-sandbox-cp-")
+		if err != nil {
+			return nil, err
+		}
+		t.staging, t.dest, dir = staging, dest, staging
+	}
+	return append(append(args, "-C", dir), rename...), nil
+}
+
+// commit moves the files unpacked into the staging dir of a stopped sandbox into place.
+func (t *CopyTarget) commit() error {
+	if t.staging == "" {
+		return nil
+	}
+	return mergeInto(t.staging, t.dest)
+}
+
+// mergeInto moves the entries of src into dst, whose path must not contain symlinks.
+// Like tar, it merges into existing directories, whose metadata it updates, and
+// replaces anything else except non-empty directories.
+func mergeInto(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())
+		existing, err := os.Lstat(to)
+		if err == nil && existing.IsDir() && e.IsDir() {
+			if err := mergeInto(from, to); err != nil {
+				return err
+			}
+			if err := copyMetadata(from, to); err != nil {
+				return err
+			}
+			continue
+		}
+		if err == nil {
+			if err := os.Remove(to); err != nil {
+				return err
+			}
+		}
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyMetadata gives the directory to the mode, ownership and times of from.
+func copyMetadata(from, to string) error {
+	info, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if err := os.Lchown(to, int(st.Uid), int(st.Gid)); err != nil {
+		return err
+	}
+	if err := os.Chmod(to, info.Mode()); err != nil {
+		return err
+	}
+	return os.Chtimes(to, info.ModTime(), info.ModTime())
+}
+
+// patternEscaper escapes the characters special in a basic regular expression, and the delimiter.
+var patternEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `^`, `\^`, `$`, `\$`, `|`, `\|`)
+
+// replacementEscaper escapes the characters special in the replacement of a tar --transform expression.
+var replacementEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, `&`, `\&`)
@@ -0,0 +1,174 @@
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+)
+
+var diskSizeRe = regexp.MustCompile(`^(\d+)([KMGT]?)$`)
+
+// parseDiskSize converts a size such as 20G to bytes.
+func parseDiskSize(size string) (int64, error) {
+	m := diskSizeRe.FindStringSubmatch(strings.ToUpper(size))
+	if m == nil {
+		return 0, fmt.Errorf("invalid disk limit %q: expected bytes with an optional K, M, G or T suffix", size)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid disk limit %q: %w", size, err)
+	}
+	shift := map[string]int{"": 0, "K": 10, "M": 20, "G": 30, "T": 40}[m[2]]
+	if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid disk limit %q: too large", size)
+	}
+	n <<= shift
+	if n < 64<<20 {
+		return 0, fmt.Errorf("disk limit %q is too small: the minimum is 64M", size)
+	}
+	return n, nil
+}
+
+// validateDiskLimit checks the disk limit and that it is not combined with --tmpfs.
+func (c SandboxConfig) validateDiskLimit() error {
+	if c.DiskLimit == "" {
+		return nil
+	}
+	if c.Tmpfs != "" {
+		return fmt.Errorf("--disk-limit and --tmpfs cannot be combined")
+	}
+	_, err := parseDiskSize(c.DiskLimit)
+	return err
+}
+
+// useDiskImage sets the sandbox's upper and work dirs inside the mounted disk image.
+func (s *Sandbox) useDiskImage() {
+	s.DiskImage = filepath.Join(s.BaseDir, "disk.img")
+	s.DiskDir = filepath.Join(s.BaseDir, "disk")
+	s.UpperDir = filepath.Join(s.DiskDir, "upper")
+	s.WorkDir = filepath.Join(s.DiskDir, "work")
+}
+
+// createDiskImage creates a sparse ext4 image of the given size to hold the upper and
+// work dirs, so that the sandbox's writes can never exceed it.
+func (s *Sandbox) createDiskImage(size string) error {
+	bytes, err := parseDiskSize(size)
+	if err != nil {
+		return err
+	}
+	s.useDiskImage()
+	if _, err := os.Stat(s.DiskImage); err == nil {
+		return s.MountDisk()
+	}
+	log.Printf("Creating %s disk image for sandbox %s", size, s.Name)
+	f, err := os.OpenFile(s.DiskImage, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(bytes)
+	f.Close()
+	if err != nil {
+		os.Remove(s.DiskImage)
+		return err
+	}
+	// No blocks reserved for root: the whole limit is usable inside the sandbox.
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", s.DiskImage).CombinedOutput(); err != nil {
+		os.Remove(s.DiskImage)
+		return fmt.Errorf("mkfs.ext4: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return s.MountDisk()
+}
+
+// MountDisk mounts the sandbox's disk image, if it has one, so that its upper dir is
+// accessible. It does nothing if the image is already mounted.
+func (s *Sandbox) MountDisk() error {
+	return s.mountDisk(false)
+}
+
+// mountDisk mounts the disk image like MountDisk. Read-only, it serves as a lower layer
+// of run sessions on top of the sandbox.
+func (s *Sandbox) mountDisk(readOnly bool) error {
+	if s.DiskImage == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.DiskDir, 0755); err != nil {
+		return err
+	}
+	if mounted, err := filesystem.IsMounted(s.DiskDir); err != nil || mounted {
+		return err
+	}
+	losetup := []string{"--find", "--show", s.DiskImage}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+	if readOnly {
+		losetup = append([]string{"--read-only"}, losetup...)
+		flags |= syscall.MS_RDONLY
+	}
+	out, err := exec.Command("losetup", losetup...).Output()
+	if err != nil {
+		return fmt.Errorf("attach loop device for %s: %w", s.DiskImage, err)
+	}
+	device := strings.TrimSpace(string(out))
+	mountErr := syscall.Mount(device, s.DiskDir, "ext4", flags, "")
+	// Detaching a loop device that is in use marks it to be freed once it is unmounted.
+	exec.Command("losetup", "--detach", device).Run()
+	if mountErr != nil {
+		return &filesystem.MountError{Op: "mount disk image on", Target: s.DiskDir, Err: mountErr}
+	}
+	if readOnly {
+		return nil
+	}
+	for _, dir := range []string{s.UpperDir, s.WorkDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiskMounted reports whether the sandbox has a disk image and it is mounted.
+func (s *Sandbox) DiskMounted() bool {
+	if s.DiskImage == "" {
+		return false
+	}
+	mounted, _ := filesystem.IsMounted(s.DiskDir)
+	return mounted
+}
+
+// UnmountDisk unmounts the sandbox's disk image, warning if the limit was reached.
+func (s *Sandbox) UnmountDisk() error {
+	if s.DiskImage == "" {
+		return nil
+	}
+	warnIfFull(s.DiskDir, "disk limit", "--disk-limit")
+	return filesystem.Unmount(s.DiskDir, filesystem.UnmountOptions{})
+}
+
+// DiskQuota returns the space used in the sandbox's disk image and its size. Both are
+// zero if the sandbox has no disk limit.
+func (s *Sandbox) DiskQuota() (used, limit uint64, err error) {
+	if s.DiskImage == "" {
+		return 0, 0, nil
+	}
+	if mounted, _ := filesystem.IsMounted(s.DiskDir); mounted {
+		return filesystem.Usage(s.DiskDir)
+	}
+	// Unmounted, the blocks allocated to the sparse image approximate its usage.
+	info, err := os.Stat(s.DiskImage)
+	if err != nil {
+		return 0, 0, err
+	}
+	st, _ := info.Sys().(*syscall.Stat_t)
+	if st != nil {
+		used = uint64(st.Blocks) * 512
+	}
+	return used, uint64(info.Size()), nil
+}
@@ -0,0 +1,191 @@
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Timezone modes handed to systemd-nspawn; any other value names a zone such as Europe/Berlin.
+const (
+	TimezoneCopy = "copy" // copy the host's /etc/localtime on each launch
+	TimezoneBind = "bind" // bind mount the host's /etc/localtime
+	TimezoneOff  = "off"  // leave the sandbox's /etc/localtime alone
+)
+
+var (
+	envKeyRe   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	hostnameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,62})?$`)
+	localeRe   = regexp.MustCompile(`^[A-Za-z_@.0-9-]+$`)
+)
+
+// validateEnvironment checks the env, hostname, timezone and locale settings.
+func (c SandboxConfig) validateEnvironment() error {
+	for _, e := range c.Env {
+		if err := validateEnvEntry(e); err != nil {
+			return err
+		}
+	}
+	if c.Hostname != "" && !hostnameRe.MatchString(c.Hostname) {
+		return fmt.Errorf("invalid hostname %q", c.Hostname)
+	}
+	switch c.Timezone {
+	case "", TimezoneCopy, TimezoneBind, TimezoneOff:
+	default:
+		if filepath.IsAbs(c.Timezone) || strings.Contains(c.Timezone, "..") {
+			return fmt.Errorf("invalid timezone %q: expected copy, bind, off or a zone like Europe/Berlin", c.Timezone)
+		}
+	}
+	if c.Locale != "" && !localeRe.MatchString(c.Locale) {
+		return fmt.Errorf("invalid locale %q", c.Locale)
+	}
+	return nil
+}
+
+func validateEnvEntry(entry string) error {
+	key, _, ok := strings.Cut(entry, "=")
+	if !ok || !envKeyRe.MatchString(key) {
+		return fmt.Errorf("invalid environment variable %q: expected KEY=VALUE", entry)
+	}
+	return nil
+}
+
+// Environment returns the variables set in the sandbox: those from the env files in
+// order, then Env, then LANG for the configured locale. Later entries win.
+func (c SandboxConfig) Environment() ([]string, error) {
+	var env []string
+	for _, path := range c.EnvFiles {
+		entries, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, entries...)
+	}
+	env = append(env, c.Env...)
+	if c.Locale != "" {
+		env = append(env, "LANG="+c.Locale)
+	}
+	return dedupEnv(env), nil
+}
+
+// readEnvFile parses KEY=VALUE lines, ignoring blank lines, comments and a leading
+// "export ". Values may be wrapped in single or double quotes.
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, _ := strings.Cut(line, "=")
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		entry := strings.TrimSpace(key) + "=" + value
+		if err := validateEnvEntry(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		env = append(env, entry)
+	}
+	return env, scanner.Err()
+}
+
+// dedupEnv keeps the last value of each variable, in the position of its first occurrence.
+func dedupEnv(env []string) []string {
+	index := map[string]int{}
+	var out []string
+	for _, e := range env {
+		key, _, _ := strings.Cut(e, "=")
+		if i, ok := index[key]; ok {
+			out[i] = e
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, e)
+	}
+	return out
+}
+
+// applyTimezone points the sandbox's /etc/localtime at a named zone and returns the
+// systemd-nspawn --timezone mode to use.
+func (s *Sandbox) applyTimezone(zone string) (string, error) {
+	switch zone {
+	case "", TimezoneCopy, TimezoneBind, TimezoneOff:
+		return zone, nil
+	}
+	target := filepath.Join("/usr/share/zoneinfo", zone)
+	if _, err := os.Stat(filepath.Join(s.OverlayDir, target)); err != nil {
+		return "", fmt.Errorf("unknown timezone %q", zone)
+	}
+	localtime := filepath.Join(s.OverlayDir, "etc/localtime")
+	if current, err := os.Readlink(localtime); err != nil || current != target {
+		os.Remove(localtime)
+		if err := os.Symlink(target, localtime); err != nil {
+			return "", fmt.Errorf("set timezone: %w", err)
+		}
+	}
+	return TimezoneOff, nil
+}
+
+// applyLocale generates the locale with locale-gen and makes it the default, unless
+// that was already done.
+func (s *Sandbox) applyLocale(locale string) error {
+	if locale == "" {
+		return nil
+	}
+	conf := "LANG=" + locale + "\n"
+	if data, err := os.ReadFile(filepath.Join(s.OverlayDir, "etc/locale.conf")); err == nil && string(data) == conf {
+		return nil
+	}
+	log.Printf("Generating locale %s", locale)
+	// locale.gen lists entries like "en_US.UTF-8 UTF-8"; add the locale if it is missing.
+	charset := "UTF-8"
+	if _, cs, ok := strings.Cut(locale, "."); ok {
+		charset = cs
+	}
+	if err := appendLine(filepath.Join(s.OverlayDir, "etc/locale.gen"), locale+" "+charset); err != nil {
+		return err
+	}
+	cmd := exec.Command("arch-chroot", s.OverlayDir, "locale-gen")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("locale-gen: %w", err)
+	}
+	return os.WriteFile(filepath.Join(s.OverlayDir, "etc/locale.conf"), []byte(conf), 0644)
+}
+
+// appendLine adds line to the file unless it already contains it.
+func appendLine(path, line string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, l := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(l) == line {
+			return nil
+		}
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line + "\n")
+	return err
+}
@@ -0,0 +1,311 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/utils"
+)
+
+// extractedMarker is written next to a sandbox's root dir once the bootstrap tarball
+// has been completely extracted into it. It holds the tarball's file name.
+const extractedMarker = "root.extracted"
+
+func (s *Sandbox) extractedMarkerPath() string {
+	return filepath.Join(filepath.Dir(s.RootDir), extractedMarker)
+}
+
+// markExtracted records that the root dir holds the complete contents of tarballPath.
+func (s *Sandbox) markExtracted(tarballPath string) error {
+	return os.WriteFile(s.extractedMarkerPath(), []byte(filepath.Base(tarballPath)+"\n"), 0644)
+}
+
+// Problem is an inconsistency in a sandbox's filesystem found by Check.
+type Problem struct {
+	Description string
+	Repair      string // what Fix does about it; empty if it has to be fixed by hand
+	fix         func() error
+}
+
+// Fix repairs the problem. The upper dir, which holds the user's changes, is never modified.
+func (p Problem) Fix() error {
+	if p.fix == nil {
+		return fmt.Errorf("cannot be repaired automatically")
+	}
+	return p.fix()
+}
+
+// maxExamples is how many damaged paths a problem description names.
+const maxExamples = 3
+
+// Check verifies the filesystem of a stopped sandbox: that the base root was
+// completely extracted and matches its tarball, that nothing is left mounted, and
+// that the overlay's upper and work dirs can be mounted again.
+func (s *Sandbox) Check() ([]Problem, error) {
+	state, err := s.State()
+	if err != nil {
+		return nil, err
+	}
+	if state != StateStopped {
+		return nil, fmt.Errorf("sandbox '%s' is %s", s.Name, state)
+	}
+	md, err := s.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if md.TarballURL != "" {
+		s.TarballURL = md.TarballURL
+	}
+
+	var problems []Problem
+	problems = append(problems, s.checkMounts()...)
+	problems = append(problems, s.checkRoot()...)
+	if s.overlayMode() != filesystem.ModeCopy && s.TmpfsDir == "" {
+		// The upper and work dirs of a sandbox with a disk limit are in its image.
+		if !s.DiskMounted() {
+			if err := s.MountDisk(); err != nil {
+				return nil, err
+			}
+			defer s.UnmountDisk()
+		}
+		problems = append(problems, s.checkOverlayDirs()...)
+	}
+	return problems, nil
+}
+
+// checkMounts finds filesystems left mounted by a sandbox that did not shut down cleanly.
+func (s *Sandbox) checkMounts() []Problem {
+	var problems []Problem
+	for _, dir := range []string{s.OverlayDir, s.DiskDir, s.TmpfsDir} {
+		if dir == "" {
+			continue
+		}
+		if mounted, _ := filesystem.IsMounted(dir); !mounted {
+			continue
+		}
+		dir := dir
+		problems = append(problems, Problem{
+			Description: fmt.Sprintf("%s is still mounted although the sandbox is stopped", dir),
+			Repair:      "unmount it",
+			fix: func() error {
+				return filesystem.Unmount(dir, filesystem.UnmountOptions{})
+			},
+		})
+	}
+	return problems
+}
+
+// checkRoot verifies the base root filesystem against the tarball it was extracted from.
+func (s *Sandbox) checkRoot() []Problem {
+	// Layered sandboxes share the root of the sandbox below them.
+	if len(s.Layers) > 0 {
+		return nil
+	}
+	reextract := func(description string) []Problem {
+		return []Problem{{
+			Description: description,
+			Repair:      "extract the base root filesystem again",
+			fix:         s.reextractRoot,
+		}}
+	}
+	entries, err := os.ReadDir(s.RootDir)
+	if err != nil || len(entries) == 0 {
+		return reextract("the base root filesystem is missing")
+	}
+	if _, err := os.Lstat(filepath.Join(s.RootDir, "root.x86_64")); err == nil {
+		return reextract("moving root.x86_64 out of the extracted tarball was interrupted")
+	}
+
+	tarballPath := filepath.Join(TarballCacheDir(filepath.Dir(s.BaseDir)), filepath.Base(s.TarballURL))
+	_, markerErr := os.Stat(s.extractedMarkerPath())
+	if _, err := os.Stat(tarballPath); err != nil {
+		if markerErr != nil {
+			return reextract("the base root filesystem has no completion marker and its tarball is not cached to verify it")
+		}
+		log.Printf("Not verifying the base root filesystem: %s is not cached", filepath.Base(tarballPath))
+		return nil
+	}
+	manifest, err := utils.TarballManifest(tarballPath)
+	if err != nil {
+		return reextract(fmt.Sprintf("the cached tarball %s cannot be read: %v", filepath.Base(tarballPath), err))
+	}
+	var missing, damaged []string
+	for _, e := range manifest {
+		info, err := os.Lstat(filepath.Join(s.RootDir, e.Path))
+		if err != nil {
+			missing = append(missing, "/"+e.Path)
+			continue
+		}
+		if !matchesEntry(info, filepath.Join(s.RootDir, e.Path), e) {
+			damaged = append(damaged, "/"+e.Path)
+		}
+	}
+	if len(missing) > 0 || len(damaged) > 0 {
+		desc := "the base root filesystem does not match its tarball"
+		if markerErr != nil {
+			desc = "the base root filesystem was not completely extracted"
+		}
+		var details []string
+		if len(missing) > 0 {
+			details = append(details, fmt.Sprintf("%d missing%s", len(missing), examples(missing)))
+		}
+		if len(damaged) > 0 {
+			details = append(details, fmt.Sprintf("%d changed%s", len(damaged), examples(damaged)))
+		}
+		return reextract(desc + ": " + strings.Join(details, ", "))
+	}
+	if markerErr != nil {
+		// Sandboxes created before the marker existed have none; having just verified
+		// the root, record it rather than report a problem.
+		log.Printf("The base root filesystem matches its tarball; adding its completion marker")
+		if err := s.markExtracted(tarballPath); err != nil {
+			log.Printf("Warning: failed to add the completion marker: %v", err)
+		}
+	}
+	return nil
+}
+
+// matchesEntry reports whether the file at path has the type, size and link target
+// recorded in the tarball. Modes and owners are changed after extraction and not compared.
+func matchesEntry(info os.FileInfo, path string, e utils.ManifestEntry) bool {
+	switch e.Type {
+	case tar.TypeDir:
+		return info.IsDir()
+	case tar.TypeSymlink:
+		target, err := os.Readlink(path)
+		return err == nil && target == e.Linkname
+	default:
+		return info.Mode().IsRegular() && info.Size() == e.Size
+	}
+}
+
+// examples formats up to maxExamples paths for a problem description.
+func examples(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+	if len(paths) > maxExamples {
+		return " (" + strings.Join(paths[:maxExamples], ", ") + ", ...)"
+	}
+	return " (" + strings.Join(paths, ", ") + ")"
+}
+
+// reextractRoot replaces the base root filesystem with a fresh extraction of the
+// tarball. The new root is extracted next to the old one and swapped in only once
+// complete, so an interrupted repair leaves the sandbox no worse than before.
+func (s *Sandbox) reextractRoot() error {
+	// Replacing the root under a mounted overlay, including that of a run session
+	// layered on the sandbox, would corrupt it.
+	for _, dir := range []string{s.RootDir, s.UpperDir} {
+		if busy, err := filesystem.InUse(dir); err != nil || busy {
+			if err == nil {
+				err = fmt.Errorf("%s is in use by a mounted filesystem", dir)
+			}
+			return err
+		}
+	}
+	fresh, old := s.RootDir+".new", s.RootDir+".old"
+	for _, dir := range []string{fresh, old} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(fresh, 0755); err != nil {
+		return err
+	}
+	tarballPath, err := s.extractTarball(fresh)
+	if err != nil {
+		os.RemoveAll(fresh)
+		return err
+	}
+	os.Remove(s.extractedMarkerPath())
+	if err := os.Rename(s.RootDir, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(fresh, s.RootDir); err != nil {
+		return err
+	}
+	if err := s.markExtracted(tarballPath); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// checkOverlayDirs checks that the upper and work dirs exist on the same filesystem
+// and that the work dir holds nothing left over from an overlay that was not
+// unmounted cleanly, which would make the next mount fail.
+func (s *Sandbox) checkOverlayDirs() []Problem {
+	var problems []Problem
+	resetWork := Problem{
+		Repair: "recreate the work dir empty",
+		fix: s.withDisk(func() error {
+			if err := os.RemoveAll(s.WorkDir); err != nil {
+				return err
+			}
+			return os.MkdirAll(s.WorkDir, 0755)
+		}),
+	}
+	upper, upperErr := os.Stat(s.UpperDir)
+	if upperErr != nil || !upper.IsDir() {
+		problems = append(problems, Problem{
+			Description: "the upper dir " + s.UpperDir + " is missing, so all changes to the sandbox are gone",
+			Repair:      "create an empty upper dir",
+			fix:         s.withDisk(func() error { return os.MkdirAll(s.UpperDir, 0755) }),
+		})
+	}
+	work, err := os.Stat(s.WorkDir)
+	if err != nil || !work.IsDir() {
+		resetWork.Description = "the work dir " + s.WorkDir + " is missing"
+		return append(problems, resetWork)
+	}
+	if upperErr == nil && !sameDevice(upper, work) {
+		problems = append(problems, Problem{
+			Description: "the upper and work dirs are on different filesystems, which overlayfs does not allow",
+		})
+	}
+	// A clean work dir only holds the empty directories overlayfs creates in it.
+	var stale []string
+	filepath.Walk(s.WorkDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == s.WorkDir {
+			return nil
+		}
+		// overlayfs prepares changes in temporary files and directories named #<hex>.
+		if !info.IsDir() || strings.HasPrefix(info.Name(), "#") {
+			rel, _ := filepath.Rel(s.WorkDir, path)
+			stale = append(stale, rel)
+		}
+		return nil
+	})
+	if len(stale) > 0 {
+		resetWork.Description = "the work dir holds leftovers from an unclean shutdown" + examples(stale)
+		problems = append(problems, resetWork)
+	}
+	return problems
+}
+
+// withDisk wraps a repair of the upper or work dir so that it runs with the sandbox's
+// disk image mounted, if it has one.
+func (s *Sandbox) withDisk(fn func() error) func() error {
+	return func() error {
+		if !s.DiskMounted() {
+			if err := s.MountDisk(); err != nil {
+				return err
+			}
+			defer s.UnmountDisk()
+		}
+		return fn()
+	}
+}
+
+// sameDevice reports whether two files are on the same filesystem.
+func sameDevice(a, b os.FileInfo) bool {
+	sa, okA := a.Sys().(*syscall.Stat_t)
+	sb, okB := b.Sys().(*syscall.Stat_t)
+	return !okA || !okB || sa.Dev == sb.Dev
+}
@@ -0,0 +1,181 @@
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+)
+
+// GUI items that can be forwarded into a sandbox with --gui.
+const (
+	GUIWayland = "wayland"
+	GUIX11     = "x11"
+	GUIAudio   = "audio" // PipeWire and PulseAudio
+	GUIDBus    = "dbus"  // D-Bus session bus
+	GUIAll     = "all"   // every item available on the host
+)
+
+var guiItems = []string{GUIWayland, GUIX11, GUIAudio, GUIDBus}
+
+// validateGUI checks the requested GUI items.
+func validateGUI(items []string) error {
+	for _, item := range items {
+		if item != GUIAll && !contains(guiItems, item) {
+			return fmt.Errorf("unknown gui item %q: valid items are %s, %s", item, strings.Join(guiItems, ", "), GUIAll)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// guiForward is what forwarding the GUI items adds to a launch.
+type guiForward struct {
+	Mounts []isolation.Mount
+	Env    []string
+}
+
+// guiUser returns the UID and GID that GUI sockets are forwarded for: the matched user,
+// else the user who ran arch-sandbox through sudo, else the current user.
+func guiUser(cfg SandboxConfig) (uid, gid int) {
+	if cfg.MatchUser != nil {
+		return cfg.MatchUser.UID, cfg.MatchUser.GID
+	}
+	if spec := InvokingUser(); spec != "" {
+		u, g, _ := isolation.ParseUser(spec)
+		uid, _ = strconv.Atoi(u)
+		gid, _ = strconv.Atoi(g)
+		return uid, gid
+	}
+	return os.Getuid(), os.Getgid()
+}
+
+// hostEnv returns an environment variable of the host session, falling back to def.
+func hostEnv(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// forwardGUI resolves the host sockets for the requested GUI items. The user's runtime
+// directory is recreated as a private tmpfs in the container and the sockets are bound
+// into it. Explicitly requested items must be available; "all" skips missing ones.
+func forwardGUI(items []string, uid, gid int) (*guiForward, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+	optional := contains(items, GUIAll)
+	if optional {
+		items = guiItems
+	}
+
+	// sudo usually drops the session variables, so fall back to the systemd defaults.
+	hostRuntime := hostEnv("XDG_RUNTIME_DIR", fmt.Sprintf("/run/user/%d", uid))
+	runtime := fmt.Sprintf("/run/user/%d", uid)
+	fwd := &guiForward{
+		Mounts: []isolation.Mount{{
+			Type:    isolation.MountTmpfs,
+			Target:  runtime,
+			Options: fmt.Sprintf("mode=0700,uid=%d,gid=%d", uid, gid),
+		}},
+		Env: []string{"XDG_RUNTIME_DIR=" + runtime},
+	}
+	bind := func(src, dst string) {
+		fwd.Mounts = append(fwd.Mounts, isolation.Mount{Type: isolation.MountBind, Source: src, Target: dst})
+	}
+
+	for _, item := range items {
+		var missing string
+		switch item {
+		case GUIWayland:
+			display := hostEnv("WAYLAND_DISPLAY", "wayland-0")
+			src := display
+			if !filepath.IsAbs(src) {
+				src = filepath.Join(hostRuntime, display)
+			}
+			if !exists(src) {
+				missing = src
+				break
+			}
+			bind(src, runtime+"/wayland-0")
+			fwd.Env = append(fwd.Env, "WAYLAND_DISPLAY=wayland-0")
+		case GUIX11:
+			if !exists("/tmp/.X11-unix") {
+				missing = "/tmp/.X11-unix"
+				break
+			}
+			bind("/tmp/.X11-unix", "/tmp/.X11-unix")
+			fwd.Env = append(fwd.Env, "DISPLAY="+hostEnv("DISPLAY", ":0"))
+			// Servers started without authentication (e.g. Xvfb) have no Xauthority.
+			if xauth := xauthority(uid); xauth != "" {
+				fwd.Mounts = append(fwd.Mounts, isolation.Mount{Type: isolation.MountBind, Source: xauth, Target: runtime + "/Xauthority", ReadOnly: true})
+				fwd.Env = append(fwd.Env, "XAUTHORITY="+runtime+"/Xauthority")
+			}
+		case GUIAudio:
+			found := false
+			if src := filepath.Join(hostRuntime, "pipewire-0"); exists(src) {
+				bind(src, runtime+"/pipewire-0")
+				found = true
+			}
+			if src := filepath.Join(hostRuntime, "pulse/native"); exists(src) {
+				bind(src, runtime+"/pulse/native")
+				fwd.Env = append(fwd.Env, "PULSE_SERVER=unix:"+runtime+"/pulse/native")
+				found = true
+			}
+			if !found {
+				missing = filepath.Join(hostRuntime, "pipewire-0")
+			}
+		case GUIDBus:
+			src := filepath.Join(hostRuntime, "bus")
+			if addr, ok := strings.CutPrefix(os.Getenv("DBUS_SESSION_BUS_ADDRESS"), "unix:path="); ok {
+				src, _, _ = strings.Cut(addr, ",")
+			}
+			if !exists(src) {
+				missing = src
+				break
+			}
+			bind(src, runtime+"/bus")
+			fwd.Env = append(fwd.Env, "DBUS_SESSION_BUS_ADDRESS=unix:path="+runtime+"/bus")
+		}
+		if missing != "" {
+			if !optional {
+				return nil, fmt.Errorf("gui %s: %s not found on the host", item, missing)
+			}
+			log.Printf("GUI %s not available on the host (%s not found), skipping", item, missing)
+		}
+	}
+	return fwd, nil
+}
+
+// xauthority returns the host X authority file of the user, if there is one.
+func xauthority(uid int) string {
+	candidates := []string{os.Getenv("XAUTHORITY")}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		candidates = append(candidates, filepath.Join(u.HomeDir, ".Xauthority"))
+	}
+	for _, c := range candidates {
+		if c != "" && exists(c) {
+			return c
+		}
+	}
+	return ""
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,184 @@
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/snapshot"
+)
+
+// HostMount describes the sandbox's filesystem mounted on the host for inspection or editing.
+type HostMount struct {
+	Path     string `yaml:"path"`
+	Snapshot string `yaml:"snapshot,omitempty"` // mounted read-only at this snapshot instead of the current state
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+}
+
+// DefaultMountPath is where the mount command mounts the sandbox if no path is given.
+func (s *Sandbox) DefaultMountPath() string {
+	return filepath.Join(s.BaseDir, "mnt")
+}
+
+// snapshotMountDir holds the snapshot extracted for a host mount.
+func (s *Sandbox) snapshotMountDir() string {
+	return filepath.Join(s.BaseDir, "mnt-snapshot")
+}
+
+// HostMount returns the sandbox's active host mount, or nil if it is not mounted on
+// the host. A recorded mount that no longer exists, e.g. after a reboot, is ignored.
+func (s *Sandbox) HostMount() (*HostMount, error) {
+	md, err := s.LoadMetadata()
+	if err != nil || md.HostMount == nil {
+		return nil, nil
+	}
+	mounted, err := filesystem.IsMounted(md.HostMount.Path)
+	if err != nil || !mounted {
+		return nil, err
+	}
+	return md.HostMount, nil
+}
+
+// MountOnHost mounts the filesystem of a stopped persistent sandbox at hm.Path without
+// launching it. A snapshot is always mounted read-only, from a temporary extraction of
+// its archive. The mount is recorded in the metadata, and the sandbox reports
+// StateMounted until UnmountFromHost, so it cannot be started or removed meanwhile.
+// It returns the mount as recorded.
+func (s *Sandbox) MountOnHost(hm HostMount) (*HostMount, error) {
+	md, err := s.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if !md.Persist {
+		return nil, fmt.Errorf("sandbox '%s' is not persistent", s.Name)
+	}
+	// Once mounted, the sandbox is no longer stopped and run refuses to layer on it.
+	// start takes the lock too, so the sandbox stays stopped while it is held.
+	unlock, err := s.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	state, err := s.State()
+	if err != nil {
+		return nil, err
+	}
+	if state != StateStopped {
+		return nil, fmt.Errorf("sandbox '%s' is %s", s.Name, state)
+	}
+	if hm.Path, err = filepath.Abs(hm.Path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(hm.Path, 0755); err != nil {
+		return nil, err
+	}
+	if mounted, err := filesystem.IsMounted(hm.Path); err != nil || mounted {
+		if err == nil {
+			err = fmt.Errorf("%s is already a mount point", hm.Path)
+		}
+		return nil, err
+	}
+
+	if hm.Snapshot != "" {
+		hm.ReadOnly = true
+		err = s.mountSnapshot(hm.Snapshot, hm.Path)
+	} else {
+		err = s.mountCurrent(hm.Path, hm.ReadOnly)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Mount may have recorded a different overlay mode.
+	if md, err = s.LoadMetadata(); err == nil {
+		md.HostMount = &hm
+		err = s.writeMetadata(md)
+	}
+	if err != nil {
+		s.unmountFromHost(&hm)
+		return nil, err
+	}
+	return &hm, nil
+}
+
+// mountCurrent bind-mounts the sandbox's assembled filesystem at path.
+func (s *Sandbox) mountCurrent(path string, readOnly bool) error {
+	if err := s.Mount(); err != nil {
+		return err
+	}
+	err := filesystem.BindMount(s.OverlayDir, path)
+	if err == nil && readOnly {
+		if err = filesystem.RemountReadOnly(path); err != nil {
+			filesystem.Unmount(path, filesystem.UnmountOptions{})
+		}
+	}
+	if err != nil {
+		s.Unmount()
+	}
+	return err
+}
+
+// mountSnapshot extracts a snapshot and mounts it, read-only, on top of the sandbox's
+// base layers at path.
+func (s *Sandbox) mountSnapshot(id, path string) error {
+	if !snapshot.Exists(s.BaseDir, id) {
+		return fmt.Errorf("sandbox '%s' has no snapshot '%s'", s.Name, id)
+	}
+	dir := s.snapshotMountDir()
+	log.Printf("Extracting snapshot '%s'", id)
+	if err := snapshot.RestoreSnapshot(s.BaseDir, dir, id); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("extract snapshot '%s': %w", id, err)
+	}
+	var err error
+	if s.overlayMode() == filesystem.ModeCopy {
+		// The snapshot of a sandbox using a plain copy holds its whole root filesystem.
+		if err = filesystem.BindMount(dir, path); err == nil {
+			if err = filesystem.RemountReadOnly(path); err != nil {
+				filesystem.Unmount(path, filesystem.UnmountOptions{})
+			}
+		}
+	} else {
+		lower := strings.Join(append(append([]string{dir}, s.Layers...), s.RootDir), ":")
+		err = filesystem.MountReadOnlyOverlay(lower, path)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+	}
+	return err
+}
+
+// UnmountFromHost undoes MountOnHost.
+func (s *Sandbox) UnmountFromHost() error {
+	md, err := s.LoadMetadata()
+	if err != nil {
+		return err
+	}
+	if md.HostMount == nil {
+		return fmt.Errorf("sandbox '%s' is not mounted on the host", s.Name)
+	}
+	if err := s.unmountFromHost(md.HostMount); err != nil {
+		return err
+	}
+	md.HostMount = nil
+	return s.writeMetadata(md)
+}
+
+func (s *Sandbox) unmountFromHost(hm *HostMount) error {
+	if err := filesystem.Unmount(hm.Path, filesystem.UnmountOptions{}); err != nil {
+		return err
+	}
+	if hm.Snapshot != "" {
+		if err := os.RemoveAll(s.snapshotMountDir()); err != nil {
+			return err
+		}
+	} else if err := s.Unmount(); err != nil {
+		return err
+	}
+	if hm.Path == s.DefaultMountPath() {
+		os.Remove(hm.Path)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+)
+
+// layerLockFile, in a sandbox's directory, is locked shared by every run session
+// layered on top of the sandbox, and exclusively by commands that change or remove it.
+const layerLockFile = ".layers.lock"
+
+func (s *Sandbox) lockLayers(how int) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(s.BaseDir, layerLockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("sandbox '%s' is in use as the base of a 'run --sandbox' session or by another command", s.Name)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lock keeps run sessions from being layered on top of the sandbox while it is
+// started, restored, mounted, repaired or removed. It fails if a session already
+// uses the sandbox as a base: changing lower layers under a mounted overlay is undefined.
+func (s *Sandbox) Lock() (unlock func(), err error) {
+	f, err := s.lockLayers(syscall.LOCK_EX)
+	if err != nil {
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// useAsBase records for the lifetime of a run session that it is layered on base.
+func (s *Sandbox) useAsBase(base *Sandbox) error {
+	f, err := base.lockLayers(syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	s.base, s.baseLock = base, f
+	return nil
+}
+
+// releaseBase ends the use of the sandbox below a run session. The last session to
+// end unmounts the base's disk image.
+func (s *Sandbox) releaseBase() {
+	if s.baseLock == nil {
+		return
+	}
+	if s.base.DiskMounted() && syscall.Flock(int(s.baseLock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil {
+		if err := filesystem.Unmount(s.base.DiskDir, filesystem.UnmountOptions{}); err != nil {
+			log.Printf("Warning: failed to unmount disk image of sandbox '%s': %v", s.base.Name, err)
+		}
+	}
+	s.baseLock.Close()
+	s.baseLock = nil
+}
@@ -0,0 +1,117 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/utils"
+)
+
+// DefaultTmpfsSize is the size of the in-memory upper dir when --tmpfs is given without one.
+const DefaultTmpfsSize = "50%"
+
+var tmpfsSizeRe = regexp.MustCompile(`^(\d+[kKmMgG]?|\d+%)$`)
+
+// validateTmpfs checks the size of a RAM-backed upper dir.
+func (c SandboxConfig) validateTmpfs() error {
+	if c.Tmpfs == "" {
+		return nil
+	}
+	if !tmpfsSizeRe.MatchString(c.Tmpfs) {
+		return fmt.Errorf("invalid tmpfs size %q: expected bytes with an optional k, m or g suffix, or a percentage of RAM", c.Tmpfs)
+	}
+	if c.Persist {
+		return fmt.Errorf("a tmpfs-backed sandbox cannot be persistent: its changes are lost on exit")
+	}
+	return nil
+}
+
+// useTmpfs moves UpperDir and WorkDir onto a tmpfs of the given size mounted inside
+// the sandbox directory, so that changes are kept in memory only.
+func (s *Sandbox) useTmpfs(size string) error {
+	s.TmpfsDir = filepath.Join(s.BaseDir, "tmpfs")
+	s.UpperDir = filepath.Join(s.TmpfsDir, "upper")
+	s.WorkDir = filepath.Join(s.TmpfsDir, "work")
+	if err := os.MkdirAll(s.TmpfsDir, 0755); err != nil {
+		return err
+	}
+	if err := filesystem.MountTmpfs(s.TmpfsDir, "size="+size+",mode=0755"); err != nil {
+		return err
+	}
+	log.Printf("Keeping changes to sandbox %s in memory (tmpfs size %s)", s.Name, size)
+	for _, dir := range []string{s.UpperDir, s.WorkDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseTmpfs reports whether the in-memory upper dir filled up and unmounts it.
+func (s *Sandbox) releaseTmpfs() error {
+	if s.TmpfsDir == "" {
+		return nil
+	}
+	warnIfFull(s.TmpfsDir, "in-memory storage", "--tmpfs")
+	return filesystem.Unmount(s.TmpfsDir, filesystem.UnmountOptions{})
+}
+
+// Thresholds, in percent of the filesystem's size, at which limited storage counts
+// as full, and below which a full filesystem is reported again when it next fills up.
+const (
+	fullPercent    = 99
+	rearmPercent   = 90
+	fullCheckEvery = 2 * time.Second
+)
+
+// usagePercent returns how full the filesystem at dir is, and its used and total bytes.
+func usagePercent(dir string) (percent, used, total uint64, ok bool) {
+	used, total, err := filesystem.Usage(dir)
+	if err != nil || total == 0 {
+		return 0, 0, 0, false
+	}
+	return used * 100 / total, used, total, true
+}
+
+// warnIfFull explains that writes failed if the filesystem at dir, which holds the
+// sandbox's upper dir and is limited by flag, is full.
+func warnIfFull(dir, what, flag string) {
+	percent, used, total, ok := usagePercent(dir)
+	if !ok || percent < fullPercent {
+		return
+	}
+	log.Printf("Warning: the sandbox's %s filled up (%s of %s); writes failed with "+
+		"\"No space left on device\". Use a larger %s size.", what, utils.HumanBytes(used), utils.HumanBytes(total), flag)
+}
+
+// watchFull checks the filesystem at dir until ctx is done and reports each time it
+// fills up, so that failing writes inside the sandbox are explained while they happen.
+func watchFull(ctx context.Context, dir, what, flag string) {
+	ticker := time.NewTicker(fullCheckEvery)
+	defer ticker.Stop()
+	reported := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		percent, used, total, ok := usagePercent(dir)
+		switch {
+		case !ok:
+		case percent >= fullPercent && !reported:
+			log.Printf("Warning: the sandbox's %s is full (%s of %s); writes inside it fail with "+
+				"\"No space left on device\" until files are deleted. Use a larger %s size next time.",
+				what, utils.HumanBytes(used), utils.HumanBytes(total), flag)
+			reported = true
+		case percent < rearmPercent:
+			reported = false
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// metadataFile is the name of the file, inside a sandbox's BaseDir, that records how it was created.
+const metadataFile = "metadata.yaml"
+
+// Metadata is the on-disk record of a sandbox, used by commands that operate on existing sandboxes.
+type Metadata struct {
+	Name       string    `yaml:"name"`
+	Persist    bool      `yaml:"persist"`
+	TarballURL string    `yaml:"tarball_url"`
+	Created    time.Time `yaml:"created"`
+	// OverlayMode is how the root filesystem is provided: overlayfs, fuse-overlayfs or copy.
+	OverlayMode string `yaml:"overlay_mode,omitempty"`
+	// HostMount records where the mount command mounted the sandbox's filesystem on the host.
+	HostMount *HostMount    `yaml:"host_mount,omitempty"`
+	Config    SandboxConfig `yaml:"config"`
+}
+
+// SaveMetadata writes the sandbox's metadata, including the effective configuration, to its BaseDir.
+func (s *Sandbox) SaveMetadata(cfg SandboxConfig) error {
+	md := Metadata{
+		Name:       s.Name,
+		Persist:    s.Persist,
+		TarballURL: s.TarballURL,
+		Created:    time.Now().UTC(),
+		Config:     cfg,
+	}
+	md.OverlayMode = s.OverlayMode
+	// Keep the original creation time when re-saving.
+	if old, err := s.LoadMetadata(); err == nil {
+		md.Created = old.Created
+		if md.OverlayMode == "" {
+			md.OverlayMode = old.OverlayMode
+		}
+		md.HostMount = old.HostMount
+	}
+	return s.writeMetadata(&md)
+}
+
+func (s *Sandbox) writeMetadata(md *Metadata) error {
+	data, err := yaml.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.BaseDir, metadataFile), data, 0644)
+}
+
+// LoadMetadata reads the sandbox's metadata from its BaseDir.
+func (s *Sandbox) LoadMetadata() (*Metadata, error) {
+	data, err := os.ReadFile(filepath.Join(s.BaseDir, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("sandbox %q not found in %s", s.Name, filepath.Dir(s.BaseDir))
+		}
+		return nil, err
+	}
+	var md Metadata
+	if err := yaml.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("parse metadata for sandbox %q: %w", s.Name, err)
+	}
+	return &md, nil
+}
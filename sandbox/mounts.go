@@ -0,0 +1,87 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/volume"
+)
+
+// VolumeStore returns the volume store shared by all sandboxes in baseDir.
+func VolumeStore(baseDir string) *volume.Store {
+	return volume.NewStore(baseDir)
+}
+
+// volumeStore returns the volume store of the sandbox's base directory.
+func (s *Sandbox) volumeStore() *volume.Store {
+	return VolumeStore(filepath.Dir(s.BaseDir))
+}
+
+// resolveMounts turns volume mounts into bind mounts of the volume's directory,
+// creating the volume on first use.
+func (s *Sandbox) resolveMounts(mounts []isolation.Mount) ([]isolation.Mount, error) {
+	resolved := make([]isolation.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		if m.Type == "" {
+			m.Type = isolation.MountBind
+		}
+		if m.Type == isolation.MountVolume {
+			v, err := s.volumeStore().Ensure(m.Source)
+			if err != nil {
+				return nil, fmt.Errorf("mount %s: %w", m.Target, err)
+			}
+			m.Type, m.Source = isolation.MountBind, v.Path
+		}
+		resolved = append(resolved, m)
+	}
+	return resolved, nil
+}
+
+// Volumes returns the names of the volumes a configuration mounts.
+func (c SandboxConfig) Volumes() []string {
+	var names []string
+	for _, m := range c.Mounts {
+		if m.Type == isolation.MountVolume {
+			names = append(names, m.Source)
+		}
+	}
+	return names
+}
+
+// VolumeUsers returns the names of the sandboxes in baseDir that use the volume:
+// those whose configuration mounts it, and running ones that had it mounted for
+// the session only.
+func VolumeUsers(baseDir, name string) ([]string, error) {
+	all, err := List(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	dataPath := VolumeStore(baseDir).DataPath(name)
+	var users []string
+	for _, md := range all {
+		if usesVolume(md, name, baseDir, dataPath) {
+			users = append(users, md.Name)
+		}
+	}
+	return users, nil
+}
+
+func usesVolume(md *Metadata, name, baseDir, dataPath string) bool {
+	for _, v := range md.Config.Volumes() {
+		if v == name {
+			return true
+		}
+	}
+	sb, err := NewSandboxWithBaseDir(md.Name, md.Persist, baseDir)
+	if err != nil {
+		return false
+	}
+	m, err := sb.Machine()
+	if err != nil {
+		return false
+	}
+	mounted, _ := filesystem.MountedIn(m.Leader, dataPath)
+	return mounted
+}
@@ -0,0 +1,127 @@
+package sandbox
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/network"
+	"gopkg.in/yaml.v3"
+)
+
+// NetworkConfig describes how a sandbox is connected.
+type NetworkConfig struct {
+	Mode   string   `yaml:"mode"`   // host, private, none or the name of a network
+	Subnet string   `yaml:"subnet"` // address range of the private network, used when it is first created
+	DNS    []string `yaml:"dns"`
+	Ports  []string `yaml:"ports"`
+	// Egress restricts outgoing connections; it needs private mode or a named network.
+	Egress network.EgressPolicy `yaml:"egress"`
+}
+
+// UnmarshalYAML also accepts the older scalar form, e.g. "network: private".
+func (n *NetworkConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		n.Mode = value.Value
+		return nil
+	}
+	type plain NetworkConfig
+	return value.Decode((*plain)(n))
+}
+
+// Validate checks the network mode and subnet.
+func (n NetworkConfig) Validate() error {
+	switch n.Mode {
+	case "", "host", "private", "none":
+	default:
+		if !network.ValidName(n.Mode) {
+			return fmt.Errorf("invalid network mode %q: expected host, private, none or a network name", n.Mode)
+		}
+	}
+	if n.Subnet != "" {
+		if _, err := netip.ParsePrefix(n.Subnet); err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", n.Subnet, err)
+		}
+	}
+	if err := n.Egress.Validate(); err != nil {
+		return err
+	}
+	if n.Egress.Restricted() && (n.Mode == "" || n.Mode == "host") {
+		return fmt.Errorf("egress policy %q needs private networking or a named network, not host networking", n.Egress.Mode)
+	}
+	return nil
+}
+
+// NetworkStore returns the network store shared by all sandboxes in baseDir. Members'
+// /etc/hosts files are kept up to date while their overlay is mounted.
+func NetworkStore(baseDir string) *network.Store {
+	store := network.NewStore(baseDir)
+	store.HostsFile = func(name string) string {
+		etc := filepath.Join(baseDir, name, "overlay", "etc")
+		if _, err := os.Stat(etc); err != nil {
+			return ""
+		}
+		return filepath.Join(etc, "hosts")
+	}
+	return store
+}
+
+// networkStore returns the store shared by all sandboxes in the same base directory.
+func (s *Sandbox) networkStore() *network.Store {
+	return NetworkStore(filepath.Dir(s.BaseDir))
+}
+
+// setupNetwork fills in the network part of the launch options. In private mode, or on
+// a named network, the sandbox gets an address on a managed bridge, which is configured
+// once it starts and before its command runs.
+func (s *Sandbox) setupNetwork(cfg NetworkConfig, opts *isolation.Options) error {
+	opts.NetworkMode = cfg.Mode
+	opts.DNS = cfg.DNS
+	opts.Ports = cfg.Ports
+
+	name := cfg.Mode
+	switch cfg.Mode {
+	case "", "host", "none":
+		return nil
+	case "private":
+		name = network.DefaultNetwork
+	}
+
+	if cfg.Egress.Restricted() {
+		// The address is configured from the host. Without these capabilities the sandbox
+		// can neither take over a peer's address nor send from it to escape its rules.
+		opts.Profile.DropCapabilities = append(append([]string{}, opts.Profile.DropCapabilities...), "CAP_NET_ADMIN", "CAP_NET_RAW")
+	}
+
+	attach := network.AttachOptions{Subnet: cfg.Subnet, Egress: cfg.Egress, Resolvers: cfg.DNS}
+	if len(attach.Resolvers) == 0 {
+		attach.Resolvers = network.HostResolvers()
+	}
+	if cfg.Egress.Mode == network.EgressMirrorsOnly {
+		dests, err := network.MirrorDestinations(filepath.Join(s.OverlayDir, "etc", "pacman.d", "mirrorlist"))
+		if err != nil {
+			return fmt.Errorf("egress policy mirrors-only: %w", err)
+		}
+		attach.Egress = network.EgressPolicy{Mode: network.EgressAllow, Allow: dests}
+	}
+
+	lease, err := s.networkStore().Attach(name, s.Name, attach)
+	if err != nil {
+		return fmt.Errorf("set up network %s: %w", name, err)
+	}
+	if err := network.WriteContainerConfig(s.OverlayDir, lease); err != nil {
+		return fmt.Errorf("write container network config: %w", err)
+	}
+	opts.NetworkBridge = lease.Bridge
+	opts.OnStart = func(m *isolation.Machine) error {
+		return network.ConfigureContainer(m.Leader, lease)
+	}
+	return nil
+}
+
+// releaseNetwork gives back the sandbox's addresses and removes unused bridges.
+func (s *Sandbox) releaseNetwork() error {
+	return s.networkStore().Release(s.Name)
+}
@@ -0,0 +1,216 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/utils"
+)
+
+// leftoverAge is how long an ephemeral sandbox or a tarball must have been left alone
+// before prune removes it, so that one still being set up is not.
+const leftoverAge = time.Hour
+
+// Prune item kinds.
+const (
+	PruneSandbox  = "sandbox"
+	PruneSnapshot = "snapshot"
+	PruneTarball  = "tarball"
+)
+
+// PrunePolicy selects what prune removes besides ephemeral leftovers and unused tarballs.
+type PrunePolicy struct {
+	SnapshotAge time.Duration // remove snapshots older than this; zero keeps all snapshots
+}
+
+// PruneItem is something prune would remove.
+type PruneItem struct {
+	Kind   string
+	Name   string
+	Path   string
+	Size   int64
+	remove func() error
+}
+
+// Remove deletes the item.
+func (i PruneItem) Remove() error {
+	return i.remove()
+}
+
+// Prunable returns what prune would remove from baseDir: stopped ephemeral sandboxes
+// left behind by a crash or a failed setup, snapshots older than the policy allows and
+// tarballs no remaining sandbox was created from.
+func Prunable(baseDir string, policy PrunePolicy) ([]PruneItem, error) {
+	names, err := Dirs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	var items []PruneItem
+	pruned := map[string]bool{}
+	// A sandbox that does not tell which tarball it came from may use any of them.
+	unknownTarball := false
+	for _, name := range names {
+		sb, err := NewSandboxWithBaseDir(name, false, baseDir)
+		if err != nil {
+			continue
+		}
+		md, err := sb.LoadMetadata()
+		if err != nil && !sb.ephemeralWithoutMetadata() {
+			// Sandboxes created before metadata existed may well be persistent.
+			unknownTarball = unknownTarball || sb.tarballName() == ""
+			continue
+		}
+		if err == nil && md.Persist {
+			snapshots, err := sb.oldSnapshots(policy.SnapshotAge)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, snapshots...)
+			continue
+		}
+		if ok, err := sb.isLeftover(); err != nil || !ok {
+			unknownTarball = unknownTarball || sb.tarballName() == ""
+			continue
+		}
+		size, _ := utils.DirSize(sb.BaseDir)
+		pruned[name] = true
+		items = append(items, PruneItem{
+			Kind:   PruneSandbox,
+			Name:   name,
+			Path:   sb.BaseDir,
+			Size:   size,
+			remove: func() error { return sb.Remove(false) },
+		})
+	}
+
+	if unknownTarball {
+		return items, nil
+	}
+	// A sandbox being set up may be downloading or extracting any tarball.
+	lock, err := lockTarballCache(baseDir, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return items, nil
+	}
+	lock.Close()
+	tarballs, err := Tarballs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tarballs {
+		used := false
+		for _, name := range t.Sandboxes {
+			used = used || !pruned[name]
+		}
+		if used || !olderThan(t.Path, leftoverAge) {
+			continue
+		}
+		path := t.Path
+		items = append(items, PruneItem{
+			Kind:   PruneTarball,
+			Name:   filepath.Base(path),
+			Path:   path,
+			Size:   t.Size,
+			remove: func() error { return removeTarball(baseDir, path) },
+		})
+	}
+	return items, nil
+}
+
+// removeTarball removes a cached tarball unless a sandbox is being set up from the
+// cache.
+func removeTarball(baseDir, path string) error {
+	lock, err := lockTarballCache(baseDir, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	return os.Remove(path)
+}
+
+// Dirs returns the names of all sandbox directories in baseDir, including those
+// without metadata, skipping the directories that hold shared state.
+func Dirs(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validateName(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// isLeftover reports whether an ephemeral sandbox can be removed: it is stopped,
+// nothing is mounted from it and it has not changed for leftoverAge.
+func (s *Sandbox) isLeftover() (bool, error) {
+	if state, err := s.State(); err != nil || state != StateStopped {
+		return false, err
+	}
+	if busy, err := filesystem.InUse(s.BaseDir); err != nil || busy {
+		return false, err
+	}
+	return olderThan(s.BaseDir, leftoverAge), nil
+}
+
+// ephemeralWithoutMetadata reports whether a sandbox directory without metadata is
+// known not to hold a persistent sandbox: it was made by run, or its setup failed
+// before the root filesystem was extracted.
+func (s *Sandbox) ephemeralWithoutMetadata() bool {
+	return strings.HasPrefix(s.Name, "run-") || !dirExists(filepath.Join(s.RootDir, "etc"))
+}
+
+// oldSnapshots returns the sandbox's snapshots older than age, with the volume archives
+// saved alongside them. A zero age selects none.
+func (s *Sandbox) oldSnapshots(age time.Duration) ([]PruneItem, error) {
+	if age == 0 {
+		return nil, nil
+	}
+	dir := filepath.Join(s.BaseDir, "snapshots")
+	matches, err := filepath.Glob(filepath.Join(dir, "*.tar.zst"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	var items []PruneItem
+	for _, path := range matches {
+		if !olderThan(path, age) {
+			continue
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ".tar.zst")
+		archive, volumes := path, filepath.Join(dir, id+".volumes")
+		size, _ := utils.DirSize(archive)
+		if n, err := utils.DirSize(volumes); err == nil {
+			size += n
+		}
+		items = append(items, PruneItem{
+			Kind: PruneSnapshot,
+			Name: s.Name + "/" + id,
+			Path: archive,
+			Size: size,
+			remove: func() error {
+				if err := os.RemoveAll(volumes); err != nil {
+					return err
+				}
+				return os.Remove(archive)
+			},
+		})
+	}
+	return items, nil
+}
+
+// olderThan reports whether path was last modified more than age ago.
+func olderThan(path string, age time.Duration) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) > age
+}
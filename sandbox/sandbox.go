@@ -1,13 +1,18 @@
 package sandbox
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/OminduD/arch-sandbox/filesystem"
 	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/pkgcache"
 	"github.com/OminduD/arch-sandbox/utils"
 	"gopkg.in/yaml.v3"
 )
@@ -19,33 +24,150 @@ const (
 
 // Sandbox defines the structure and paths for an isolated environment.
 type Sandbox struct {
-	Name       string
-	Persist    bool
-	BaseDir    string
-	RootDir    string // Lower dir for overlayfs
-	UpperDir   string // Upper dir for overlayfs
-	WorkDir    string // Work dir for overlayfs
-	OverlayDir string // Mount point for overlayfs
-	TarballURL string
+	Name        string
+	Persist     bool
+	BaseDir     string
+	RootDir     string   // Lower dir for overlayfs
+	Layers      []string // Read-only layers stacked on RootDir, topmost first
+	UpperDir    string   // Upper dir for overlayfs
+	WorkDir     string   // Work dir for overlayfs
+	OverlayDir  string   // Mount point for overlayfs
+	TmpfsDir    string   // tmpfs holding UpperDir and WorkDir, if the sandbox is RAM-backed
+	DiskImage   string   // ext4 image limiting the size of UpperDir, if the sandbox has a disk limit
+	DiskDir     string   // mount point of DiskImage
+	OverlayMode string   // how the root filesystem is provided: overlayfs, fuse-overlayfs or copy
+	TarballURL  string
+
+	cacheLock *pkgcache.Lock // shared lock on the package cache while the sandbox runs
+	base      *Sandbox       // sandbox a run session is layered on
+	baseLock  *os.File       // shared lock on base's layer lock file
 }
 
 // SandboxConfig defines sandbox configurations from a file
 type SandboxConfig struct {
-	Name     string   `yaml:"name"`
-	Persist  bool     `yaml:"persist"`
-	Tarball  string   `yaml:"tarball"`
-	Packages []string `yaml:"packages"`
-	Mounts   []struct {
-		Source string `yaml:"source"`
-		Target string `yaml:"target"`
-	} `yaml:"mounts"`
-	Network string `yaml:"network"`
+	Name      string              `yaml:"name"`
+	Persist   bool                `yaml:"persist"`
+	Tarball   string              `yaml:"tarball"`
+	Packages  []string            `yaml:"packages"`
+	Mounts    []isolation.Mount   `yaml:"mounts"`
+	Network   NetworkConfig       `yaml:"network"`
+	Resources isolation.Resources `yaml:"resources"`
+	Profile   string              `yaml:"profile"`
+	// Timeout and IdleTimeout are durations such as "30m"; empty means no limit.
+	Timeout     string `yaml:"timeout"`
+	IdleTimeout string `yaml:"idle_timeout"`
+	// Command, if set, runs instead of an interactive shell, in Workdir and as User (UID[:GID]).
+	Command []string `yaml:"command"`
+	Workdir string   `yaml:"workdir"`
+	User    string   `yaml:"user"`
+	// MatchUser, if set, creates a copy of the host user that becomes the default login.
+	MatchUser *UserConfig `yaml:"match_user,omitempty"`
+	// GUI lists the host display, audio and bus sockets to forward: wayland, x11, audio, dbus or all.
+	GUI []string `yaml:"gui"`
+	// Env holds KEY=VALUE variables, applied after those read from EnvFiles on the host.
+	Env      []string `yaml:"env"`
+	EnvFiles []string `yaml:"env_files"`
+	Hostname string   `yaml:"hostname"`
+	Timezone string   `yaml:"timezone"` // copy, bind, off or a zone such as Europe/Berlin
+	Locale   string   `yaml:"locale"`   // generated with locale-gen, e.g. en_US.UTF-8
+	Secrets  []Secret `yaml:"secrets"`
+	// Tmpfs, if set, keeps the upper dir on a tmpfs of this size, e.g. 2G or 25%.
+	Tmpfs string `yaml:"tmpfs"`
+	// DiskLimit, if set, caps the upper dir by keeping it in a disk image of this size, e.g. 20G.
+	DiskLimit string `yaml:"disk_limit"`
+	// NoSharedCache keeps downloaded packages in the sandbox instead of the shared cache.
+	NoSharedCache bool `yaml:"no_shared_cache"`
+}
+
+// Validate checks the configuration before anything is created on disk.
+func (c SandboxConfig) Validate() error {
+	if err := c.Network.Validate(); err != nil {
+		return err
+	}
+	if _, err := isolation.LookupProfile(c.Profile); err != nil {
+		return err
+	}
+	if err := c.Resources.Validate(); err != nil {
+		return err
+	}
+	for _, m := range c.Mounts {
+		if err := m.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := parseDuration("timeout", c.Timeout); err != nil {
+		return err
+	}
+	if _, err := parseDuration("idle timeout", c.IdleTimeout); err != nil {
+		return err
+	}
+	if c.Workdir != "" && !filepath.IsAbs(c.Workdir) {
+		return fmt.Errorf("workdir %q must be an absolute path", c.Workdir)
+	}
+	if c.User != "" {
+		if err := isolation.ValidateUser(c.User); err != nil {
+			return err
+		}
+	}
+	if c.MatchUser != nil {
+		if err := c.MatchUser.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateGUI(c.GUI); err != nil {
+		return err
+	}
+	for _, secret := range c.Secrets {
+		if err := secret.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateTmpfs(); err != nil {
+		return err
+	}
+	if err := c.validateDiskLimit(); err != nil {
+		return err
+	}
+	return c.validateEnvironment()
+}
+
+// parseDuration parses an optional, non-negative duration; the empty string means zero.
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a duration like 30m or 1h30m", name, value)
+	}
+	return d, nil
+}
+
+// reservedNames are directories in the base directory that hold shared state rather than sandboxes.
+var reservedNames = map[string]bool{
+	"cache":    true,
+	"networks": true,
+	"volumes":  true,
+}
+
+// validateName rejects names that are not a single path element or collide with shared state.
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid sandbox name %q", name)
+	}
+	if reservedNames[name] {
+		return fmt.Errorf("sandbox name %q is reserved", name)
+	}
+	return nil
 }
 
 // NewSandboxWithBaseDir creates a new Sandbox struct with all paths configured.
 func NewSandboxWithBaseDir(name string, persist bool, baseDir string) (*Sandbox, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
 	sandboxBase := filepath.Join(baseDir, name)
-	return &Sandbox{
+	sb := &Sandbox{
 		Name:       name,
 		Persist:    persist,
 		BaseDir:    sandboxBase,
@@ -54,17 +176,42 @@ func NewSandboxWithBaseDir(name string, persist bool, baseDir string) (*Sandbox,
 		WorkDir:    filepath.Join(sandboxBase, "work"),
 		OverlayDir: filepath.Join(sandboxBase, "overlay"),
 		TarballURL: tarballURL, // Default URL
-	}, nil
+	}
+	// A running RAM-backed sandbox keeps its upper dir on a tmpfs.
+	if tmpfs := filepath.Join(sandboxBase, "tmpfs"); dirExists(filepath.Join(tmpfs, "upper")) {
+		sb.TmpfsDir = tmpfs
+		sb.UpperDir = filepath.Join(tmpfs, "upper")
+		sb.WorkDir = filepath.Join(tmpfs, "work")
+	}
+	// A sandbox with a disk limit keeps its upper dir in a disk image.
+	if _, err := os.Stat(filepath.Join(sandboxBase, "disk.img")); err == nil {
+		sb.useDiskImage()
+	}
+	return sb, nil
 }
 
-// NewSandboxFromConfig creates a new sandbox from a YAML configuration file.
-func NewSandboxFromConfig(configPath string) (*Sandbox, error) {
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// LoadConfig reads a SandboxConfig from a YAML file.
+func LoadConfig(configPath string) (SandboxConfig, error) {
+	var cfg SandboxConfig
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, err
+		return cfg, err
 	}
-	var cfg SandboxConfig
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse config %s: %w", configPath, err)
+	}
+	return cfg, nil
+}
+
+// NewSandboxFromConfig creates a new sandbox from a YAML configuration file.
+func NewSandboxFromConfig(configPath string) (*Sandbox, error) {
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
 		return nil, err
 	}
 	// Assuming the base directory is managed outside or defaults are used.
@@ -82,6 +229,21 @@ func NewSandboxFromConfig(configPath string) (*Sandbox, error) {
 // Setup creates directories, downloads and extracts the Arch bootstrap tarball, and sets up the overlayfs.
 func (s *Sandbox) Setup(cfg SandboxConfig) error {
 	dirs := []string{s.BaseDir, s.RootDir, s.UpperDir, s.WorkDir, s.OverlayDir}
+	if cfg.Tmpfs != "" {
+		if err := s.useTmpfs(cfg.Tmpfs); err != nil {
+			return err
+		}
+		dirs = []string{s.RootDir, s.OverlayDir}
+	}
+	if cfg.DiskLimit != "" {
+		if err := os.MkdirAll(s.BaseDir, 0755); err != nil {
+			return err
+		}
+		if err := s.createDiskImage(cfg.DiskLimit); err != nil {
+			return err
+		}
+		dirs = []string{s.RootDir, s.OverlayDir}
+	}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return err
@@ -93,22 +255,24 @@ func (s *Sandbox) Setup(cfg SandboxConfig) error {
 		return err
 	}
 
-	// Define a shared cache directory for tarballs to avoid re-downloading.
-	tarballCacheDir := filepath.Join(s.BaseDir, "..", ".cache")
-	if err := os.MkdirAll(tarballCacheDir, 0755); err != nil {
-		return err
-	}
-	tarballPath := filepath.Join(tarballCacheDir, filepath.Base(s.TarballURL))
-
-	if err := utils.DownloadTarball(s.TarballURL, tarballPath); err != nil {
-		return err
+	// A layered sandbox reuses the root filesystem of the sandbox below it.
+	if s.base == nil {
+		tarballPath, err := s.extractTarball(s.RootDir)
+		if err != nil {
+			return err
+		}
+		if err := s.markExtracted(tarballPath); err != nil {
+			return err
+		}
 	}
-	if err := utils.ExtractTarball(tarballPath, s.RootDir); err != nil {
+	if err := s.Mount(); err != nil {
 		return err
 	}
-	if err := filesystem.SetupOverlay(s.RootDir, s.UpperDir, s.WorkDir, s.OverlayDir); err != nil {
+	releaseCache, err := s.MountPackageCache(cfg)
+	if err != nil {
 		return err
 	}
+	defer releaseCache()
 
 	for _, pkg := range cfg.Packages {
 		log.Printf("Installing package: %s", pkg)
@@ -119,51 +283,273 @@ func (s *Sandbox) Setup(cfg SandboxConfig) error {
 			return err
 		}
 	}
-	for _, mount := range cfg.Mounts {
-		log.Printf("Binding mount from %s to %s", mount.Source, mount.Target)
-		targetPath := filepath.Join(s.OverlayDir, mount.Target)
-		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+	if cfg.MatchUser != nil {
+		if err := s.createUser(cfg.MatchUser); err != nil {
 			return err
 		}
-		cmd := exec.Command("mount", "--bind", mount.Source, targetPath)
-		if err := cmd.Run(); err != nil {
+	}
+	return s.applyLocale(cfg.Locale)
+}
+
+// Mount mounts the overlayfs of an already set up sandbox so it can be launched again.
+func (s *Sandbox) Mount() error {
+	if _, err := os.Stat(filepath.Join(s.RootDir, "etc")); err != nil {
+		return fmt.Errorf("sandbox %s has no root filesystem in %s", s.Name, s.RootDir)
+	}
+	if err := s.MountDisk(); err != nil {
+		return err
+	}
+	// The upper dir of a base with a disk limit is in its image.
+	if s.base != nil {
+		if err := s.base.mountDisk(true); err != nil {
 			return err
 		}
 	}
+	lower := strings.Join(append(append([]string{}, s.Layers...), s.RootDir), ":")
+
+	// A new sandbox may use any mode. An existing one keeps its changes either in the upper
+	// dir, which both overlay implementations understand, or in a plain copy.
+	modes := filesystem.AllModes
+	recorded := s.overlayMode()
+	switch {
+	case recorded == filesystem.ModeCopy:
+		modes = []string{filesystem.ModeCopy}
+	case recorded != "":
+		modes = []string{filesystem.ModeOverlay, filesystem.ModeFuse}
+	case s.DiskImage != "" || s.TmpfsDir != "":
+		// A copy is written outside the image or tmpfs and would escape the limit.
+		modes = []string{filesystem.ModeOverlay, filesystem.ModeFuse}
+	}
+	mode, err := filesystem.SetupLayers(lower, s.UpperDir, s.WorkDir, s.OverlayDir, modes)
+	if err != nil {
+		return err
+	}
+	s.OverlayMode = mode
+	if recorded != "" && mode != recorded {
+		if md, err := s.LoadMetadata(); err == nil {
+			log.Printf("Sandbox %s now uses %s instead of %s", s.Name, mode, recorded)
+			return s.SaveMetadata(md.Config)
+		}
+	}
 	return nil
 }
 
-// Launch starts the systemd-nspawn container.
-func (s *Sandbox) Launch(networkMode string, dns []string, ports []string, cpuShares, memoryLimit string) error {
+// Unmount unmounts the filesystem of a sandbox that was mounted with Mount.
+func (s *Sandbox) Unmount() error {
+	if err := filesystem.UnmountOverlay(s.OverlayDir); err != nil {
+		return err
+	}
+	return s.UnmountDisk()
+}
+
+// overlayMode returns how the sandbox's filesystem is provided, as recorded in its
+// metadata. Sandboxes created before the mode was recorded use kernel overlayfs;
+// a sandbox without metadata has no mode yet.
+func (s *Sandbox) overlayMode() string {
+	if s.OverlayMode != "" {
+		return s.OverlayMode
+	}
+	md, err := s.LoadMetadata()
+	if err != nil {
+		return ""
+	}
+	if md.OverlayMode == "" {
+		return filesystem.ModeOverlay
+	}
+	return md.OverlayMode
+}
+
+// WritableDir returns the directory holding the sandbox's changes: the upper dir, or
+// the whole root filesystem for a sandbox that uses a plain copy.
+func (s *Sandbox) WritableDir() string {
+	if s.overlayMode() == filesystem.ModeCopy {
+		return s.OverlayDir
+	}
+	return s.UpperDir
+}
+
+// Launch starts the systemd-nspawn container and waits for the session to end or ctx to be cancelled.
+func (s *Sandbox) Launch(ctx context.Context, cfg SandboxConfig) error {
 	log.Printf("Launching sandbox %s", s.Name)
-	return isolation.LaunchNspawn(s.OverlayDir, s.Name, networkMode, dns, ports, cpuShares, memoryLimit)
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	profile, _ := isolation.LookupProfile(cfg.Profile)
+	timeout, _ := parseDuration("timeout", cfg.Timeout)
+	idleTimeout, _ := parseDuration("idle timeout", cfg.IdleTimeout)
+	mounts := cfg.Mounts
+	if cfg.MatchUser != nil {
+		mounts = append(append([]isolation.Mount{}, mounts...), cfg.MatchUser.Mounts()...)
+		if cfg.User == "" {
+			cfg.User = cfg.MatchUser.Name
+		}
+	}
+	env, err := cfg.Environment()
+	if err != nil {
+		return err
+	}
+	if err := s.applyLocale(cfg.Locale); err != nil {
+		return err
+	}
+	timezone, err := s.applyTimezone(cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	if len(cfg.GUI) > 0 {
+		uid, gid := guiUser(cfg)
+		gui, err := forwardGUI(cfg.GUI, uid, gid)
+		if err != nil {
+			return err
+		}
+		mounts = append(append([]isolation.Mount{}, mounts...), gui.Mounts...)
+		env = append(gui.Env, env...)
+		// The sockets are only accessible to the user they belong to.
+		if cfg.User == "" && uid != 0 {
+			cfg.User = fmt.Sprintf("%d:%d", uid, gid)
+		}
+	}
+	mounts = append(append([]isolation.Mount{}, mounts...), secretMounts(cfg.Secrets)...)
+	if cfg.usesPackageCache(profile) {
+		cache, err := s.packageCacheMount()
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, cache)
+	}
+	mounts, err = s.resolveMounts(mounts)
+	if err != nil {
+		return err
+	}
+	opts := isolation.Options{
+		Directory:   s.OverlayDir,
+		Machine:     s.Name,
+		Mounts:      mounts,
+		Resources:   cfg.Resources,
+		Profile:     profile,
+		Timeout:     timeout,
+		IdleTimeout: idleTimeout,
+		Command:     cfg.Command,
+		Chdir:       cfg.Workdir,
+		User:        cfg.User,
+		Env:         env,
+		Hostname:    cfg.Hostname,
+		Timezone:    timezone,
+	}
+	if cfg.User != "" && !isolation.IsUserName(cfg.User) {
+		// A bare UID usually has no home directory in the container.
+		opts.Env = append([]string{"HOME=/tmp"}, opts.Env...)
+	}
+	if err := s.setupNetwork(cfg.Network, &opts); err != nil {
+		return err
+	}
+	// Explain failing writes as soon as limited storage fills up.
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	if s.TmpfsDir != "" {
+		go watchFull(watchCtx, s.TmpfsDir, "in-memory storage", "--tmpfs")
+	}
+	if s.DiskImage != "" {
+		go watchFull(watchCtx, s.DiskDir, "disk limit", "--disk-limit")
+	}
+	return isolation.LaunchNspawn(ctx, opts)
 }
 
-// Cleanup unmounts the overlayfs and removes the sandbox directory if not persistent.
+// Cleanup releases the sandbox's network addresses, unmounts the overlayfs and removes
+// the sandbox directory if not persistent.
 func (s *Sandbox) Cleanup() error {
+	if err := s.releaseNetwork(); err != nil {
+		log.Printf("Warning: failed to release network: %v", err)
+	}
+	s.cacheLock.Unlock()
+	s.cacheLock = nil
+
 	log.Println("Unmounting overlayfs...")
-	if err := filesystem.UnmountOverlay(s.OverlayDir); err != nil {
-		// Log the error but don't stop, still try to clean up if not persisting
-		log.Printf("Warning: failed to unmount overlayfs: %v", err)
+	unmountErr := filesystem.UnmountOverlay(s.OverlayDir)
+	if unmountErr != nil {
+		log.Printf("Warning: failed to unmount overlayfs: %v", unmountErr)
+	} else if err := s.releaseTmpfs(); err != nil {
+		log.Printf("Warning: failed to unmount tmpfs: %v", err)
+		unmountErr = err
+	} else if err := s.UnmountDisk(); err != nil {
+		log.Printf("Warning: failed to unmount disk image: %v", err)
+		unmountErr = err
+	}
+	if unmountErr == nil {
+		s.releaseBase()
 	}
 
 	if s.Persist {
 		log.Printf("Persisting sandbox '%s' at %s", s.Name, s.BaseDir)
 		return nil
 	}
+	// Removing the directory through a still mounted overlay would delete files of the
+	// layers below it.
+	if unmountErr != nil {
+		return fmt.Errorf("not removing sandbox %s while its overlay is mounted: %w", s.Name, unmountErr)
+	}
 
 	log.Printf("Cleaning up sandbox %s", s.Name)
 	return os.RemoveAll(s.BaseDir)
 }
 
-// InstallAURHelper installs an AUR helper like 'yay' into the sandbox.
-func (s *Sandbox) InstallAURHelper(helper string) error {
+// Remove deletes a stopped sandbox and everything under its directory. Named volumes
+// live outside the sandbox directory and are left alone. With force, a busy overlay or
+// a mount on the host is detached lazily instead of failing.
+func (s *Sandbox) Remove(force bool) error {
+	state, err := s.State()
+	if err != nil {
+		return err
+	}
+	var hostMount string
+	switch {
+	case state == StateMounted && !force:
+		return fmt.Errorf("sandbox '%s' is mounted on the host; unmount it first", s.Name)
+	case state == StateMounted:
+		if hm, err := s.HostMount(); err == nil && hm != nil {
+			hostMount = hm.Path
+		}
+	case state != StateStopped:
+		return fmt.Errorf("sandbox '%s' is %s", s.Name, state)
+	}
+	if _, err := os.Stat(s.BaseDir); err != nil {
+		return fmt.Errorf("sandbox '%s' does not exist", s.Name)
+	}
+	// Not even force may remove the lower layers of a run session's overlay.
+	unlock, err := s.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.releaseNetwork(); err != nil {
+		log.Printf("Warning: failed to release network: %v", err)
+	}
+	// The overlay is normally unmounted already; a leftover mount must not be removed through.
+	for _, dir := range []string{hostMount, s.OverlayDir, s.DiskDir} {
+		if dir == "" {
+			continue
+		}
+		if err := filesystem.Unmount(dir, filesystem.UnmountOptions{Lazy: force}); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Removing sandbox %s", s.Name)
+	return os.RemoveAll(s.BaseDir)
+}
+
+// InstallAURHelper installs an AUR helper like 'yay' into the sandbox. It is built as the
+// matched user if there is one, since makepkg refuses to run as root, and as a 'builder'
+// user otherwise.
+func (s *Sandbox) InstallAURHelper(helper string, matched *UserConfig) error {
 	log.Printf("Installing AUR helper '%s'", helper)
+	builder := "builder"
+	if matched != nil {
+		builder = matched.Name
+	}
 	script := `
 pacman -S --noconfirm --needed git base-devel && \
-useradd -m builder && \
-chown -R builder:builder /home/builder && \
-su builder -c 'cd /tmp && git clone https://aur.archlinux.org/` + helper + `.git && cd ` + helper + ` && makepkg -si --noconfirm'
+{ id -u ` + builder + ` >/dev/null 2>&1 || useradd -m ` + builder + `; } && \
+su ` + builder + ` -c 'cd /tmp && rm -rf ` + helper + ` && git clone https://aur.archlinux.org/` + helper + `.git && cd ` + helper + ` && makepkg -si --noconfirm'
 `
 	cmd := exec.Command("arch-chroot", s.OverlayDir, "/bin/bash", "-c", script)
 	cmd.Stdout = os.Stdout
@@ -0,0 +1,75 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+)
+
+// SecretsDir is where secrets appear inside the sandbox.
+const SecretsDir = "/run/secrets"
+
+// Secret is a host file made available read-only at /run/secrets/<id>. It is bind
+// mounted at launch, so its contents never reach the upper dir or snapshots.
+type Secret struct {
+	ID  string `yaml:"id"`
+	Src string `yaml:"src"`
+}
+
+var secretIDRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ParseSecret parses a --secret argument of the form id=name,src=file.
+func ParseSecret(spec string) (Secret, error) {
+	var s Secret
+	for _, field := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "id":
+			s.ID = value
+		case "src", "source":
+			s.Src = value
+		default:
+			return Secret{}, fmt.Errorf("invalid secret %q: expected id=name,src=file", spec)
+		}
+	}
+	if s.Src != "" {
+		abs, err := filepath.Abs(s.Src)
+		if err != nil {
+			return Secret{}, err
+		}
+		s.Src = abs
+	}
+	return s, s.Validate()
+}
+
+// Validate checks the secret's id and that its source is an absolute path.
+func (s Secret) Validate() error {
+	if !secretIDRe.MatchString(s.ID) {
+		return fmt.Errorf("invalid secret id %q", s.ID)
+	}
+	if !filepath.IsAbs(s.Src) {
+		return fmt.Errorf("secret %s: source %q must be an absolute path", s.ID, s.Src)
+	}
+	return nil
+}
+
+// secretMounts returns a tmpfs at SecretsDir with each secret bound read-only inside it.
+func secretMounts(secrets []Secret) []isolation.Mount {
+	if len(secrets) == 0 {
+		return nil
+	}
+	mounts := []isolation.Mount{{Type: isolation.MountTmpfs, Target: SecretsDir, Options: "mode=0755"}}
+	for _, s := range secrets {
+		mounts = append(mounts, isolation.Mount{
+			Type:      isolation.MountBind,
+			Source:    s.Src,
+			Target:    SecretsDir + "/" + s.ID,
+			ReadOnly:  true,
+			Sensitive: true,
+		})
+	}
+	return mounts
+}
@@ -0,0 +1,90 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/OminduD/arch-sandbox/cgroup"
+	"github.com/OminduD/arch-sandbox/isolation"
+)
+
+// Sandbox states as reported by State.
+const (
+	StateStopped = "stopped"
+	StateRunning = "running"
+	StatePaused  = "paused"
+	StateMounted = "mounted" // stopped, with its filesystem mounted on the host by the mount command
+)
+
+// State reports whether the sandbox is stopped, running, paused or mounted on the host.
+func (s *Sandbox) State() (string, error) {
+	dir, err := s.freezerDir()
+	if errors.Is(err, isolation.ErrNotRunning) {
+		if hm, err := s.HostMount(); err != nil || hm != nil {
+			return StateMounted, err
+		}
+		return StateStopped, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	frozen, err := cgroup.Frozen(dir)
+	if err != nil {
+		return "", err
+	}
+	if frozen {
+		return StatePaused, nil
+	}
+	return StateRunning, nil
+}
+
+// Pause freezes all processes of the running sandbox.
+func (s *Sandbox) Pause() error {
+	dir, err := s.freezerDir()
+	if err != nil {
+		return err
+	}
+	log.Printf("Pausing sandbox %s", s.Name)
+	return cgroup.Freeze(dir)
+}
+
+// Resume thaws a sandbox frozen with Pause.
+func (s *Sandbox) Resume() error {
+	dir, err := s.freezerDir()
+	if err != nil {
+		return err
+	}
+	log.Printf("Resuming sandbox %s", s.Name)
+	return cgroup.Thaw(dir)
+}
+
+// WhilePaused runs fn with the sandbox frozen so that its filesystem is consistent.
+// A stopped sandbox is left alone, and a sandbox that was already paused stays paused.
+func (s *Sandbox) WhilePaused(fn func() error) error {
+	state, err := s.State()
+	if err != nil {
+		return err
+	}
+	if state != StateRunning {
+		return fn()
+	}
+	if err := s.Pause(); err != nil {
+		return err
+	}
+	fnErr := fn()
+	if err := s.Resume(); err != nil {
+		return fmt.Errorf("resume sandbox %s: %w", s.Name, err)
+	}
+	return fnErr
+}
+
+// freezerDir returns the cgroup holding the sandbox's processes. When systemd-nspawn
+// splits its scope, only the payload is frozen so the supervisor keeps serving the terminal.
+func (s *Sandbox) freezerDir() (string, error) {
+	m, err := s.Machine()
+	if err != nil {
+		return "", err
+	}
+	return m.ProcessDir(), nil
+}
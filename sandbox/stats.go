@@ -0,0 +1,125 @@
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OminduD/arch-sandbox/cgroup"
+	"github.com/OminduD/arch-sandbox/isolation"
+	"github.com/OminduD/arch-sandbox/utils"
+)
+
+// Stats combines a sandbox's cgroup counters with its network and disk usage.
+type Stats struct {
+	Name string `json:"name"`
+	cgroup.Stats
+	NetRxBytes uint64 `json:"net_rx_bytes"`
+	NetTxBytes uint64 `json:"net_tx_bytes"`
+	DiskUsage  int64  `json:"disk_usage"`           // bytes used by the upper dir
+	DiskLimit  uint64 `json:"disk_limit,omitempty"` // size of the disk image, if limited
+	// DiskMeasured is false when the upper dir was not walked and DiskUsage is unknown.
+	DiskMeasured bool `json:"-"`
+	// Time is when the cgroup counters were read.
+	Time time.Time `json:"-"`
+}
+
+// List returns the metadata of every sandbox under baseDir, sorted by name.
+func List(baseDir string) ([]*Metadata, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var sandboxes []*Metadata
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		sb, err := NewSandboxWithBaseDir(entry.Name(), true, baseDir)
+		if err != nil {
+			// Shared state such as volumes, or the tarball cache.
+			continue
+		}
+		md, err := sb.LoadMetadata()
+		if err != nil {
+			// Not a sandbox (e.g. the tarball cache) or created before metadata existed.
+			continue
+		}
+		sandboxes = append(sandboxes, md)
+	}
+	sort.Slice(sandboxes, func(i, j int) bool { return sandboxes[i].Name < sandboxes[j].Name })
+	return sandboxes, nil
+}
+
+// Machine returns the running container for the sandbox, or isolation.ErrNotRunning.
+func (s *Sandbox) Machine() (*isolation.Machine, error) {
+	return isolation.LookupMachine(s.Name)
+}
+
+// Stats collects resource usage for a running sandbox. Walking the upper dir to measure
+// its size is slow, so without walkDisk it is skipped unless the sandbox has a disk
+// image, whose usage is read from the filesystem.
+func (s *Sandbox) Stats(walkDisk bool) (*Stats, error) {
+	m, err := s.Machine()
+	if err != nil {
+		return nil, err
+	}
+	cg, err := cgroup.ReadStats(cgroup.Path(m.ControlGroup))
+	if err != nil {
+		return nil, fmt.Errorf("read cgroup stats for %s: %w", s.Name, err)
+	}
+	st := &Stats{Name: s.Name, Stats: *cg, Time: time.Now(), DiskMeasured: true}
+	st.NetRxBytes, st.NetTxBytes, err = readNetDev(m.Leader)
+	if err != nil {
+		return nil, fmt.Errorf("read network stats for %s: %w", s.Name, err)
+	}
+	if s.DiskImage != "" {
+		var used uint64
+		if used, st.DiskLimit, err = s.DiskQuota(); err != nil {
+			return nil, fmt.Errorf("measure disk usage for %s: %w", s.Name, err)
+		}
+		st.DiskUsage = int64(used)
+	} else if !walkDisk {
+		st.DiskMeasured = false
+	} else if st.DiskUsage, err = utils.DirSize(s.WritableDir()); err != nil {
+		return nil, fmt.Errorf("measure disk usage for %s: %w", s.Name, err)
+	}
+	return st, nil
+}
+
+// readNetDev sums the receive and transmit byte counters of all non-loopback
+// interfaces in the network namespace of pid.
+func readNetDev(pid int) (rx, tx uint64, err error) {
+	f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "net", "dev"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Interface lines look like "  eth0: rxbytes rxpackets ... txbytes txpackets ...";
+		// the two header lines have no colon-separated interface name.
+		iface, counters, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(iface) == "lo" {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(fields[0], 10, 64)
+		t, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += r
+		tx += t
+	}
+	return rx, tx, scanner.Err()
+}
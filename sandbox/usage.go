@@ -0,0 +1,167 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/utils"
+)
+
+// TarballCacheDir returns the directory holding downloaded bootstrap tarballs.
+func TarballCacheDir(baseDir string) string {
+	return filepath.Join(baseDir, ".cache")
+}
+
+// lockTarballCache locks the tarball cache: shared while a tarball is downloaded and
+// extracted, and exclusively, without waiting, by prune to remove tarballs.
+func lockTarballCache(baseDir string, how int) (*os.File, error) {
+	dir := TarballCacheDir(baseDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errors.New("the tarball cache is in use by a sandbox being set up")
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// extractTarball downloads the sandbox's tarball into the cache, unless it is there
+// already, and extracts it into dest. It returns the tarball's path in the cache.
+func (s *Sandbox) extractTarball(dest string) (string, error) {
+	baseDir := filepath.Dir(s.BaseDir)
+	lock, err := lockTarballCache(baseDir, syscall.LOCK_SH)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+	tarballPath := filepath.Join(TarballCacheDir(baseDir), filepath.Base(s.TarballURL))
+	if err := utils.DownloadTarball(s.TarballURL, tarballPath); err != nil {
+		return "", err
+	}
+	return tarballPath, utils.ExtractTarball(tarballPath, dest)
+}
+
+// tarballName returns the file name of the tarball the sandbox was created from,
+// from its metadata or, for sandboxes without metadata, its root's completion
+// marker. It is empty if neither tells.
+func (s *Sandbox) tarballName() string {
+	if md, err := s.LoadMetadata(); err == nil && md.TarballURL != "" {
+		return filepath.Base(md.TarballURL)
+	}
+	data, err := os.ReadFile(s.extractedMarkerPath())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Usage is the disk space taken by a sandbox, in bytes.
+type Usage struct {
+	Root      int64 // extracted base root filesystem
+	Upper     int64 // the sandbox's changes, including a disk image or plain copy
+	Snapshots int64
+	Other     int64 // metadata and anything else in the sandbox directory
+}
+
+// Total returns the space taken by the whole sandbox directory.
+func (u Usage) Total() int64 {
+	return u.Root + u.Upper + u.Snapshots + u.Other
+}
+
+// DiskUsage measures the sandbox's directory. Mount points are skipped so nothing is
+// counted twice, and a RAM-backed upper dir is not counted at all.
+func (s *Sandbox) DiskUsage() (*Usage, error) {
+	entries, err := os.ReadDir(s.BaseDir)
+	if err != nil {
+		return nil, err
+	}
+	var u Usage
+	for _, entry := range entries {
+		path := filepath.Join(s.BaseDir, entry.Name())
+		var field *int64
+		switch entry.Name() {
+		case "root":
+			field = &u.Root
+		case "upper", "work", "disk.img":
+			field = &u.Upper
+		case "overlay":
+			// Only a sandbox using a plain copy keeps files in its overlay dir.
+			if s.overlayMode() != filesystem.ModeCopy {
+				continue
+			}
+			field = &u.Upper
+		case "snapshots":
+			field = &u.Snapshots
+		case "tmpfs", "disk":
+			continue
+		default:
+			field = &u.Other
+		}
+		if mounted, _ := filesystem.IsMounted(path); mounted {
+			continue
+		}
+		n, err := utils.DirSize(path)
+		if err != nil {
+			return nil, err
+		}
+		*field += n
+	}
+	return &u, nil
+}
+
+// Tarball is a bootstrap tarball in the tarball cache.
+type Tarball struct {
+	Path      string
+	Size      int64
+	Sandboxes []string // sandboxes created from it
+}
+
+// Tarballs returns the cached tarballs and the sandboxes that were created from each,
+// as far as their metadata or root tells.
+func Tarballs(baseDir string) ([]*Tarball, error) {
+	entries, err := os.ReadDir(TarballCacheDir(baseDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	byName := map[string]*Tarball{}
+	var tarballs []*Tarball
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		t := &Tarball{Path: filepath.Join(TarballCacheDir(baseDir), entry.Name())}
+		if info, err := entry.Info(); err == nil {
+			t.Size = info.Size()
+		}
+		byName[entry.Name()] = t
+		tarballs = append(tarballs, t)
+	}
+	names, err := Dirs(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		sb, err := NewSandboxWithBaseDir(name, true, baseDir)
+		if err != nil {
+			continue
+		}
+		if t := byName[sb.tarballName()]; t != nil {
+			t.Sandboxes = append(t.Sandboxes, name)
+		}
+	}
+	sort.Slice(tarballs, func(i, j int) bool { return tarballs[i].Path < tarballs[j].Path })
+	return tarballs, nil
+}
@@ -0,0 +1,144 @@
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/OminduD/arch-sandbox/isolation"
+)
+
+// UserConfig describes a user created inside the sandbox to mirror the host user.
+type UserConfig struct {
+	Name     string   `yaml:"name"`
+	UID      int      `yaml:"uid"`
+	GID      int      `yaml:"gid"`
+	Shell    string   `yaml:"shell"`
+	HostHome string   `yaml:"host_home"`
+	Sudo     bool     `yaml:"sudo"`      // passwordless sudo inside the sandbox
+	BindHome bool     `yaml:"bind_home"` // mount the whole host home over the user's home
+	Dotfiles []string `yaml:"dotfiles"`  // files from the host home mounted read-only, e.g. .gitconfig
+}
+
+// HostUser returns the user who invoked arch-sandbox, looking through sudo.
+func HostUser() (*UserConfig, error) {
+	var (
+		u   *user.User
+		err error
+	)
+	if name := os.Getenv("SUDO_USER"); name != "" && name != "root" {
+		u, err = user.Lookup(name)
+	} else {
+		u, err = user.Current()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Uid == "0" {
+		return nil, fmt.Errorf("cannot match the host user: arch-sandbox was not run by a regular user through sudo")
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	return &UserConfig{
+		Name:     u.Username,
+		UID:      uid,
+		GID:      gid,
+		Shell:    loginShell(u.Username),
+		HostHome: u.HomeDir,
+	}, nil
+}
+
+// loginShell reads the user's shell from /etc/passwd, defaulting to bash.
+func loginShell(name string) string {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return "/bin/bash"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == name && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return "/bin/bash"
+}
+
+// Validate checks the user's name and that dotfiles stay inside the home directory.
+func (u *UserConfig) Validate() error {
+	if !isolation.IsUserName(u.Name) || u.Name == "root" {
+		return fmt.Errorf("invalid user name %q", u.Name)
+	}
+	if u.UID <= 0 || u.GID <= 0 {
+		return fmt.Errorf("user %s: UID and GID must be positive", u.Name)
+	}
+	if (u.BindHome || len(u.Dotfiles) > 0) && !filepath.IsAbs(u.HostHome) {
+		return fmt.Errorf("user %s: host home %q must be an absolute path", u.Name, u.HostHome)
+	}
+	for _, f := range u.Dotfiles {
+		if filepath.IsAbs(f) || f == ".." || strings.HasPrefix(filepath.Clean(f), "../") {
+			return fmt.Errorf("user %s: dotfile %q must be relative to the home directory", u.Name, f)
+		}
+	}
+	return nil
+}
+
+// Home returns the user's home directory inside the sandbox.
+func (u *UserConfig) Home() string {
+	return "/home/" + u.Name
+}
+
+// Mounts returns the bind mounts of the host home or dotfiles. Dotfiles missing on the
+// host are skipped.
+func (u *UserConfig) Mounts() []isolation.Mount {
+	if u.BindHome {
+		return []isolation.Mount{{Type: isolation.MountBind, Source: u.HostHome, Target: u.Home()}}
+	}
+	var mounts []isolation.Mount
+	for _, f := range u.Dotfiles {
+		src := filepath.Join(u.HostHome, f)
+		if _, err := os.Stat(src); err != nil {
+			log.Printf("Warning: skipping dotfile %s: %v", f, err)
+			continue
+		}
+		mounts = append(mounts, isolation.Mount{Type: isolation.MountBind, Source: src, Target: filepath.Join(u.Home(), f), ReadOnly: true})
+	}
+	return mounts
+}
+
+// createUser adds the user to the sandbox, with sudo rights if requested.
+func (s *Sandbox) createUser(u *UserConfig) error {
+	log.Printf("Creating user %s (%d:%d) in sandbox %s", u.Name, u.UID, u.GID, s.Name)
+	shell := u.Shell
+	if _, err := os.Stat(filepath.Join(s.OverlayDir, shell)); err != nil {
+		log.Printf("Shell %s is not installed in the sandbox, using /bin/bash", shell)
+		shell = "/bin/bash"
+	}
+	script := fmt.Sprintf(`
+getent group %[2]d >/dev/null || groupadd -g %[2]d %[1]s && \
+{ id -u %[1]s >/dev/null 2>&1 || useradd -m -u %[3]d -g %[2]d -s %[4]s %[1]s; }
+`, u.Name, u.GID, u.UID, shell)
+	if u.Sudo {
+		script += "pacman -S --noconfirm --needed sudo\n"
+	}
+	cmd := exec.Command("arch-chroot", s.OverlayDir, "/bin/bash", "-e", "-c", script)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("create user %s: %w", u.Name, err)
+	}
+	if u.Sudo {
+		rule := u.Name + " ALL=(ALL:ALL) NOPASSWD: ALL\n"
+		if err := os.WriteFile(filepath.Join(s.OverlayDir, "etc/sudoers.d/arch-sandbox-"+u.Name), []byte(rule), 0440); err != nil {
+			return fmt.Errorf("grant sudo to %s: %w", u.Name, err)
+		}
+	}
+	return nil
+}
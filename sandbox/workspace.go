@@ -0,0 +1,61 @@
+package sandbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/OminduD/arch-sandbox/filesystem"
+	"github.com/OminduD/arch-sandbox/isolation"
+)
+
+// WorkspaceDir is where run mounts the project directory inside the sandbox.
+const WorkspaceDir = "/workspace"
+
+// NewRunSandbox returns an ephemeral sandbox with a generated name. If base is not nil,
+// the new sandbox is layered on top of it: base's files are visible read-only and all
+// changes go to the new sandbox's own upper dir, which is discarded on cleanup. base is
+// locked against changes until Cleanup.
+func NewRunSandbox(baseDir string, base *Sandbox) (*Sandbox, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	sb, err := NewSandboxWithBaseDir("run-"+hex.EncodeToString(suffix), false, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		if err := sb.useAsBase(base); err != nil {
+			return nil, err
+		}
+		if base.overlayMode() == filesystem.ModeCopy {
+			// A sandbox using a plain copy keeps its whole root filesystem there.
+			sb.RootDir = base.OverlayDir
+		} else {
+			sb.RootDir = base.RootDir
+			sb.Layers = append([]string{base.UpperDir}, base.Layers...)
+		}
+		sb.TarballURL = base.TarballURL
+	}
+	return sb, nil
+}
+
+// WorkspaceMount binds the host directory dir at WorkspaceDir.
+func WorkspaceMount(dir string) isolation.Mount {
+	return isolation.Mount{Type: isolation.MountBind, Source: dir, Target: WorkspaceDir}
+}
+
+// InvokingUser returns the UID:GID of the user who ran arch-sandbox through sudo,
+// or "" when it was not run through sudo.
+func InvokingUser() string {
+	uid, gid := os.Getenv("SUDO_UID"), os.Getenv("SUDO_GID")
+	if uid == "" || uid == "0" {
+		return ""
+	}
+	if gid == "" {
+		gid = uid
+	}
+	return fmt.Sprintf("%s:%s", uid, gid)
+}
@@ -1,25 +1,82 @@
 package snapshot
 
 import (
-    "os"
-    "os/exec"
-    "path/filepath"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
-func SaveSnapshot(sandboxDir, snapshotName string) error {
-    snapshotPath := filepath.Join(sandboxDir, "snapshots", snapshotName+".tar.zst")
-    if err := os.MkdirAll(filepath.Dir(snapshotPath), 0755); err != nil {
-        return err
-    }
-    cmd := exec.Command("tar", "-C", filepath.Join(sandboxDir, "upper"), "--zstd", "-cf", snapshotPath, ".")
-    return cmd.Run()
+// Excluded lists paths, relative to the sandbox root, that are never archived. Secrets
+// are mounted at launch and should not be in the upper dir, but a mount point or a
+// stray copy left there must not end up in a snapshot either.
+var Excluded = []string{"./run/secrets"}
+
+func SaveSnapshot(sandboxDir, upperDir, snapshotName string) error {
+	snapshotPath := filepath.Join(sandboxDir, "snapshots", snapshotName+".tar.zst")
+	if err := os.MkdirAll(filepath.Dir(snapshotPath), 0755); err != nil {
+		return err
+	}
+	args := []string{"-C", upperDir, "--zstd", "-cf", snapshotPath}
+	for _, path := range Excluded {
+		args = append(args, "--exclude="+path)
+	}
+	cmd := exec.Command("tar", append(args, ".")...)
+	return cmd.Run()
+}
+
+func RestoreSnapshot(sandboxDir, upperDir, snapshotName string) error {
+	os.RemoveAll(upperDir)
+	os.MkdirAll(upperDir, 0755)
+	snapshotPath := filepath.Join(sandboxDir, "snapshots", snapshotName+".tar.zst")
+	cmd := exec.Command("tar", "-C", upperDir, "--zstd", "-xf", snapshotPath)
+	return cmd.Run()
+}
+
+// Exists reports whether the sandbox has a snapshot of that name.
+func Exists(sandboxDir, snapshotName string) bool {
+	_, err := os.Stat(filepath.Join(sandboxDir, "snapshots", snapshotName+".tar.zst"))
+	return err == nil
+}
+
+// volumesDir holds the volume archives saved alongside a snapshot.
+func volumesDir(sandboxDir, snapshotName string) string {
+	return filepath.Join(sandboxDir, "snapshots", snapshotName+".volumes")
+}
+
+// SaveVolume archives the data of a volume as part of a snapshot. Volumes are only
+// included in snapshots when asked for, since they usually outlive the sandbox.
+func SaveVolume(sandboxDir, snapshotName, volumeName, dataDir string) error {
+	dir := volumesDir(sandboxDir, snapshotName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	cmd := exec.Command("tar", "-C", dataDir, "--zstd", "-cf", filepath.Join(dir, volumeName+".tar.zst"), ".")
+	return cmd.Run()
 }
 
-func RestoreSnapshot(sandboxDir, snapshotName string) error {
-    upperDir := filepath.Join(sandboxDir, "upper")
-    os.RemoveAll(upperDir)
-    os.MkdirAll(upperDir, 0755)
-    snapshotPath := filepath.Join(sandboxDir, "snapshots", snapshotName+".tar.zst")
-    cmd := exec.Command("tar", "-C", upperDir, "--zstd", "-xf", snapshotPath)
-    return cmd.Run()
-}
\ No newline at end of file
+// SnapshotVolumes returns the names of the volumes saved with a snapshot.
+func SnapshotVolumes(sandboxDir, snapshotName string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(volumesDir(sandboxDir, snapshotName), "*.tar.zst"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), ".tar.zst"))
+	}
+	return names, nil
+}
+
+// RestoreVolume replaces the contents of dataDir with the volume archive saved in a snapshot.
+func RestoreVolume(sandboxDir, snapshotName, volumeName, dataDir string) error {
+	if err := os.RemoveAll(dataDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+	archive := filepath.Join(volumesDir(sandboxDir, snapshotName), volumeName+".tar.zst")
+	cmd := exec.Command("tar", "-C", dataDir, "--zstd", "-xf", archive)
+	return cmd.Run()
+}
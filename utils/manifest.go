@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"archive/tar"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// ManifestEntry is a file that ExtractTarball creates, with its path relative to the
+// directory the tarball is extracted to.
+type ManifestEntry struct {
+	Path     string
+	Type     byte // tar.TypeDir, tar.TypeReg or tar.TypeSymlink
+	Size     int64
+	Linkname string
+}
+
+// TarballManifest lists the files ExtractTarball creates from a tarball, with the
+// root.x86_64 directory of Arch bootstrap tarballs already moved away.
+func TarballManifest(tarballPath string) ([]ManifestEntry, error) {
+	cmd := exec.Command("zstd", "-d", "--stdout", tarballPath)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var entries []ManifestEntry
+	tr := tar.NewReader(stdout)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cmd.Wait()
+			return nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+		default:
+			// ExtractTarball skips everything else.
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(header.Name, "./"), "root.x86_64/")
+		name = strings.TrimSuffix(name, "/")
+		if name == "" || name == "." || name == "root.x86_64" {
+			continue
+		}
+		entries = append(entries, ManifestEntry{
+			Path:     name,
+			Type:     header.Typeflag,
+			Size:     header.Size,
+			Linkname: header.Linkname,
+		})
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 func CheckDependencies() error {
@@ -176,3 +177,44 @@ func moveContents(src, dst string) error {
 	}
 	return nil
 }
+
+// DirSize returns the disk space used by the files under path, counting hard links once.
+func DirSize(path string) (int64, error) {
+	var size int64
+	seen := make(map[uint64]bool)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if st.Nlink > 1 {
+				if seen[st.Ino] {
+					return nil
+				}
+				seen[st.Ino] = true
+			}
+			size += st.Blocks * 512
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// HumanBytes formats a byte count using binary units, e.g. 1.5G.
+func HumanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%c", float64(n)/float64(div), "KMGTPE"[exp])
+}
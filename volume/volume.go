@@ -0,0 +1,131 @@
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// metadataFile records a volume's creation details next to its data directory.
+const metadataFile = "volume.yaml"
+
+// Volume is a named directory that can be mounted into any sandbox and outlives them.
+type Volume struct {
+	Name    string    `yaml:"name"`
+	Created time.Time `yaml:"created"`
+	Path    string    `yaml:"-"` // host directory holding the volume's data
+}
+
+var nameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Store manages volumes under <base-dir>/volumes.
+type Store struct {
+	Dir string
+}
+
+// NewStore returns the volume store for a sandbox base directory.
+func NewStore(baseDir string) *Store {
+	return &Store{Dir: filepath.Join(baseDir, "volumes")}
+}
+
+// DataPath returns the directory holding the data of the named volume.
+func (s *Store) DataPath(name string) string {
+	return filepath.Join(s.Dir, name, "data")
+}
+
+// validateName rejects names that are not a single, plain path element.
+func validateName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q: use letters, digits, '.', '-' and '_'", name)
+	}
+	return nil
+}
+
+// Create makes a new, empty volume.
+func (s *Store) Create(name string) (*Volume, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	if _, err := s.Get(name); err == nil {
+		return nil, fmt.Errorf("volume %q already exists", name)
+	}
+	v := &Volume{Name: name, Created: time.Now().UTC(), Path: s.DataPath(name)}
+	if err := os.MkdirAll(v.Path, 0755); err != nil {
+		return nil, err
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir, name, metadataFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Ensure returns the named volume, creating it if it does not exist yet.
+func (s *Store) Ensure(name string) (*Volume, error) {
+	v, err := s.Get(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return s.Create(name)
+	}
+	return v, err
+}
+
+// Get loads the named volume. The error wraps os.ErrNotExist if there is none.
+func (s *Store) Get(name string) (*Volume, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, name, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("volume %q does not exist: %w", name, os.ErrNotExist)
+		}
+		return nil, err
+	}
+	var v Volume
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("parse volume %q: %w", name, err)
+	}
+	v.Path = s.DataPath(name)
+	return &v, nil
+}
+
+// List returns all volumes sorted by name.
+func (s *Store) List() ([]*Volume, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var volumes []*Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, err := s.Get(entry.Name())
+		if err != nil {
+			continue
+		}
+		volumes = append(volumes, v)
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, nil
+}
+
+// Remove deletes a volume and all of its data.
+func (s *Store) Remove(name string) error {
+	if _, err := s.Get(name); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.Dir, name))
+}